@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// configErrors collects every problem found in the configuration so they can be reported at once
+type configErrors []error
+
+func (e configErrors) Error() string {
+	lines := []string{"invalid configuration:"}
+	for _, err := range e {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FileConfig is the declarative config file passed with --config. JSON is accepted too as it is a
+// subset of YAML.
+type FileConfig struct {
+	Checks []CheckConfig `yaml:"checks"`
+}
+
+// CheckConfig declares a single check instance
+type CheckConfig struct {
+	Type        string                 `yaml:"type"`
+	Name        string                 `yaml:"name"`
+	Description string                 `yaml:"description"`
+	Interval    string                 `yaml:"interval"`
+	Timeout     string                 `yaml:"timeout"`
+	Rise        int                    `yaml:"rise"`
+	Fall        int                    `yaml:"fall"`
+	Critical    *bool                  `yaml:"critical"`
+	DependsOn   []string               `yaml:"depends_on"`
+	Params      map[string]interface{} `yaml:"params"`
+}
+
+// checkFactory builds a check of one type from the env derived Config and its declaration
+type checkFactory func(cfg Config, cc CheckConfig) (CheckInterface, error)
+
+// checkTypes maps the type of a declared check to its factory
+var checkTypes = map[string]checkFactory{
+	"dns":      newCheckDNSFromConfig,
+	"http":     newCheckHTTPFromConfig,
+	"metadata": newCheckMetadataFromConfig,
+	"storage":  newCheckStorageFromConfig,
+}
+
+func defaultChecks(cfg Config) []CheckInterface {
+	return []CheckInterface{NewCheckDNS(cfg), NewCheckMetadata(cfg), NewCheckStorage(cfg)}
+}
+
+// loadChecks returns the checks declared in the config file at path, or the built in checks when no
+// file is given
+func loadChecks(cfg Config, path string) ([]CheckInterface, error) {
+	if path == "" {
+		return defaultChecks(cfg), nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fileConfig FileConfig
+	if err := yaml.UnmarshalStrict(data, &fileConfig); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return buildChecks(cfg, fileConfig)
+}
+
+func buildChecks(cfg Config, fileConfig FileConfig) ([]CheckInterface, error) {
+	var errs configErrors
+	if len(fileConfig.Checks) == 0 {
+		errs = append(errs, fmt.Errorf("no checks declared"))
+	}
+	checks := []CheckInterface{}
+	names := map[string]bool{}
+	for i, cc := range fileConfig.Checks {
+		factory, ok := checkTypes[cc.Type]
+		if !ok {
+			errs = append(errs, fmt.Errorf("check %d: unknown type %q", i, cc.Type))
+			continue
+		}
+		check, err := factory(cfg, cc)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("check %d (%s): %v", i, cc.Type, err))
+			continue
+		}
+		if names[check.getName()] {
+			errs = append(errs, fmt.Errorf("check %d (%s): duplicate name %q, give each check a unique name", i, cc.Type, check.getName()))
+			continue
+		}
+		names[check.getName()] = true
+		checks = append(checks, check)
+	}
+	errs = append(errs, dependencyErrors(checks)...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return checks, nil
+}
+
+// apply sets the settings common to every check type on c
+func (cc CheckConfig) apply(c *Check) error {
+	if cc.Name != "" {
+		c.name = cc.Name
+	}
+	if cc.Description != "" {
+		c.description = cc.Description
+	}
+	if cc.Interval != "" {
+		interval, err := time.ParseDuration(cc.Interval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("interval: %q is not a positive duration such as 30s", cc.Interval)
+		}
+		c.interval = interval
+	}
+	if cc.Timeout != "" {
+		timeout, err := time.ParseDuration(cc.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("timeout: %q is not a positive duration such as 10s", cc.Timeout)
+		}
+		c.timeout = timeout
+	}
+	if cc.Rise < 0 || cc.Fall < 0 {
+		return fmt.Errorf("rise and fall must be at least 1")
+	}
+	if cc.Rise != 0 {
+		c.rise = cc.Rise
+	}
+	if cc.Fall != 0 {
+		c.fall = cc.Fall
+	}
+	if cc.Critical != nil {
+		c.nonCritical = !*cc.Critical
+	}
+	c.dependsOn = cc.DependsOn
+	return nil
+}
+
+// decodeParams decodes the type specific params of a check into out, rejecting unknown keys
+func (cc CheckConfig) decodeParams(out interface{}) error {
+	if len(cc.Params) == 0 {
+		return nil
+	}
+	data, err := yaml.Marshal(cc.Params)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(data, out); err != nil {
+		return fmt.Errorf("params: %v", err)
+	}
+	return nil
+}
+
+type metadataParams struct {
+	URL string `yaml:"url"`
+}
+
+func newCheckMetadataFromConfig(cfg Config, cc CheckConfig) (CheckInterface, error) {
+	check := NewCheckMetadata(cfg)
+	params := metadataParams{URL: check.url}
+	if err := cc.decodeParams(&params); err != nil {
+		return nil, err
+	}
+	check.url = params.URL
+	if err := cc.apply(&check.Check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+type storageParams struct {
+	DataSpaceThreshold         string `yaml:"data_space_threshold"`
+	MetadataSpaceThreshold     string `yaml:"metadata_space_threshold"`
+	DataSpaceWarnThreshold     string `yaml:"data_space_warn_threshold"`
+	MetadataSpaceWarnThreshold string `yaml:"metadata_space_warn_threshold"`
+	InodeThreshold             string `yaml:"inode_threshold"`
+	InodeWarnThreshold         string `yaml:"inode_warn_threshold"`
+}
+
+func newCheckStorageFromConfig(cfg Config, cc CheckConfig) (CheckInterface, error) {
+	// declaring a storage check in the config file enables it regardless of ENABLE_STORAGE_CHECK
+	cfg.enableStorageCheck = true
+	params := storageParams{
+		DataSpaceThreshold:         cfg.dataStorageThreshold.String(),
+		MetadataSpaceThreshold:     cfg.metaDataStorageThreshold.String(),
+		DataSpaceWarnThreshold:     cfg.dataStorageWarn.String(),
+		MetadataSpaceWarnThreshold: cfg.metaDataStorageWarn.String(),
+		InodeThreshold:             cfg.inodeThreshold.String(),
+		InodeWarnThreshold:         cfg.inodeWarn.String(),
+	}
+	if err := cc.decodeParams(&params); err != nil {
+		return nil, err
+	}
+	for _, threshold := range []struct {
+		name   string
+		raw    string
+		target *storageThreshold
+		parse  func(string) (storageThreshold, error)
+	}{
+		{"data_space_threshold", params.DataSpaceThreshold, &cfg.dataStorageThreshold, parseStorageThreshold},
+		{"metadata_space_threshold", params.MetadataSpaceThreshold, &cfg.metaDataStorageThreshold, parseStorageThreshold},
+		{"data_space_warn_threshold", params.DataSpaceWarnThreshold, &cfg.dataStorageWarn, parseStorageThreshold},
+		{"metadata_space_warn_threshold", params.MetadataSpaceWarnThreshold, &cfg.metaDataStorageWarn, parseStorageThreshold},
+		{"inode_threshold", params.InodeThreshold, &cfg.inodeThreshold, parseInodeThreshold},
+		{"inode_warn_threshold", params.InodeWarnThreshold, &cfg.inodeWarn, parseInodeThreshold},
+	} {
+		parsed, err := threshold.parse(threshold.raw)
+		if err != nil {
+			return nil, fmt.Errorf("params: %s: %v", threshold.name, err)
+		}
+		*threshold.target = parsed
+	}
+	check := NewCheckStorage(cfg)
+	if err := cc.apply(&check.Check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "cowcheck-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadChecksDefault(t *testing.T) {
+	checks, err := loadChecks(Config{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 3 {
+		t.Errorf("Expected the 3 built in checks, got %d", len(checks))
+	}
+}
+
+func TestLoadChecksFromFile(t *testing.T) {
+	path := writeConfigFile(t, `
+checks:
+  - type: dns
+    name: dns-internal
+    interval: 5s
+    rise: 2
+    fall: 3
+  - type: dns
+    name: dns-external
+    timeout: 2s
+    params:
+      query: example.com
+  - type: metadata
+  - type: storage
+    critical: false
+    params:
+      data_space_threshold: 5GiB
+      metadata_space_warn_threshold: 20%
+`)
+	defer os.Remove(path)
+
+	checks, err := loadChecks(Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 4 {
+		t.Fatalf("Expected 4 checks, got %d", len(checks))
+	}
+
+	internal := checks[0].(*CheckDNS)
+	if internal.name != "dns-internal" || internal.interval != 5*time.Second || internal.rise != 2 || internal.fall != 3 {
+		t.Errorf("Common settings not applied to %s", internal.name)
+	}
+	external := checks[1].(*CheckDNS)
+	if len(external.queries) != 1 || external.queries[0].name != "example.com" || external.timeout != 2*time.Second {
+		t.Errorf("DNS params not applied: queries %v timeout %v", external.queries, external.timeout)
+	}
+	if checks[2].getName() != "CheckMetadata" {
+		t.Errorf("Expected default name CheckMetadata, got %s", checks[2].getName())
+	}
+	storage := checks[3].(*CheckStorage)
+	if !storage.cfg.enableStorageCheck || storage.cfg.dataStorageThreshold.amount != 5*1024*1024*1024 ||
+		storage.cfg.metaDataStorageWarn.percent != 20 {
+		t.Errorf("Storage params not applied: %+v", storage.cfg)
+	}
+	if storage.isCritical() || !checks[2].isCritical() {
+		t.Errorf("Expected only the storage check to be non-critical")
+	}
+}
+
+func TestLoadChecksErrors(t *testing.T) {
+	for content, expected := range map[string]string{
+		"checks:\n  - type: ping\n":                                              "unknown type",
+		"checks:\n  - type: dns\n  - type: dns\n":                                "duplicate name",
+		"checks:\n  - type: dns\n    params:\n      qeury: example.com\n":        "params",
+		"checks:\n  - type: dns\n    interval: often\n":                          "interval",
+		"checks:\n  - type: dns\n    depends_on: [CheckDNS]\n":                   "dependency cycle",
+		"checks:\n  - type: storage\n    params:\n      inode_threshold: 5GiB\n": "inode_threshold",
+	} {
+		path := writeConfigFile(t, content)
+		_, err := loadChecks(Config{}, path)
+		os.Remove(path)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q for %q, got %v", expected, content, err)
+		}
+	}
+}
+
+func TestLoadChecksCollectsAllErrors(t *testing.T) {
+	path := writeConfigFile(t, "checks:\n  - type: ping\n  - type: dns\n    timeout: -1s\n  - type: metadata\n")
+	defer os.Remove(path)
+
+	_, err := loadChecks(Config{}, path)
+	errs, ok := err.(configErrors)
+	if !ok || len(errs) != 2 {
+		t.Errorf("Expected 2 config errors, got %v", err)
+	}
+}
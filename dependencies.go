@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// blockedBy returns the critical dependencies of check that are unhealthy, failed their last evaluation
+// or are skipped themselves, a check with any of those is not worth evaluating as its failure would only
+// repeat theirs. Non-critical dependencies never block, their failure mustn't hide the failure of a
+// critical check.
+func blockedBy(check CheckInterface, checks []CheckInterface) []string {
+	var blocked []string
+	for _, dependency := range criticalDependencies(check, checks) {
+		status := dependency.getLastResult().Status
+		if !dependency.getStatus() || status == StatusFail || status == StatusSkipped {
+			blocked = append(blocked, dependency.getName())
+		}
+	}
+	return blocked
+}
+
+func criticalDependencies(check CheckInterface, checks []CheckInterface) []CheckInterface {
+	var dependencies []CheckInterface
+	for _, name := range check.getDependencies() {
+		if dependency := findCheck(checks, name); dependency != nil && dependency.isCritical() {
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	return dependencies
+}
+
+// evalCheck runs check unless one of its dependencies is failing, in which case it is recorded as
+// skipped so a single root cause doesn't fail every check relying on it. Every check runs on its own
+// timer, so when check fails its dependencies are evaluated again first: one that only started failing
+// since its last evaluation still takes the blame.
+func evalCheck(ctx context.Context, check CheckInterface, checks []CheckInterface) {
+	if blocked := blockedBy(check, checks); len(blocked) > 0 {
+		recordSkipped(check, blocked)
+		return
+	}
+	result, ok := evaluate(ctx, check)
+	if !ok {
+		return
+	}
+	if result.Status == StatusFail {
+		dependencies := criticalDependencies(check, checks)
+		for _, dependency := range dependencies {
+			evalCheck(ctx, dependency, checks)
+		}
+		if blocked := blockedBy(check, checks); len(blocked) > 0 {
+			recordSkipped(check, blocked)
+			return
+		}
+	}
+	check.record(result)
+}
+
+func recordSkipped(check CheckInterface, blocked []string) {
+	check.record(CheckResult{
+		Status:  StatusSkipped,
+		Message: "blocked by failing dependency " + strings.Join(blocked, ", "),
+		Time:    time.Now(),
+	})
+}
+
+// dependencyErrors reports dependencies on unknown checks and dependency cycles, which would block
+// the checks involved forever
+func dependencyErrors(checks []CheckInterface) []error {
+	var errs []error
+	for _, check := range checks {
+		for _, name := range check.getDependencies() {
+			if findCheck(checks, name) == nil {
+				errs = append(errs, fmt.Errorf("check %s: depends_on unknown check %q", check.getName(), name))
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var visit func(check CheckInterface, path []string)
+	visit = func(check CheckInterface, path []string) {
+		name := check.getName()
+		path = append(path, name)
+		switch state[name] {
+		case visiting:
+			errs = append(errs, fmt.Errorf("dependency cycle: %s", strings.Join(path, " -> ")))
+			return
+		case visited:
+			return
+		}
+		state[name] = visiting
+		for _, dependency := range check.getDependencies() {
+			if next := findCheck(checks, dependency); next != nil {
+				visit(next, path)
+			}
+		}
+		state[name] = visited
+	}
+	for _, check := range checks {
+		visit(check, nil)
+	}
+	return errs
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDependentChecks returns a healthy dns check and a healthy metadata check depending on it
+func newDependentChecks() (*ToggleCheck, *ToggleCheck) {
+	dns := NewToggleCheck()
+	dns.name = "dns"
+	metadata := NewToggleCheck()
+	metadata.name = "metadata"
+	metadata.dependsOn = []string{"dns"}
+	return dns, metadata
+}
+
+// evalSequentially evaluates checks one after the other and updates the node health, so a check
+// sees the result its dependencies had in the same cycle
+func evalSequentially(checks ...CheckInterface) {
+	for _, check := range checks {
+		evalCheck(context.Background(), check, checkSlice)
+	}
+	updateNodeHealth(checkSlice)
+}
+
+func TestDependencySkipsDependents(t *testing.T) {
+	dns, metadata := newDependentChecks()
+	withChecks(t, metadata, dns)
+
+	dns.healthy = false
+	metadata.healthy = false
+	evalSequentially(dns, metadata)
+
+	if getNodeHealth() {
+		t.Errorf("Expected the failing dependency to make the node unhealthy")
+	}
+	if result := metadata.getLastResult(); result.Status != StatusSkipped || result.Message != "blocked by failing dependency dns" {
+		t.Errorf("Expected the dependent check to be skipped, got %+v", result)
+	}
+	if !metadata.getStatus() {
+		t.Errorf("Expected the skipped check to keep its health")
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(checkState).ServeHTTP(rr, httptest.NewRequest("GET", "/health?verbose", nil))
+	var detail HealthDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	skipped := detail.Checks[0]
+	if skipped.Severity != StatusSkipped || len(skipped.DependsOn) != 1 || len(skipped.BlockedBy) != 1 || skipped.BlockedBy[0] != "dns" {
+		t.Errorf("unexpected detail for the skipped check: %+v", skipped)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(readyz).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz?exclude=dns", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("readyz returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	dns.healthy = true
+	evalSequentially(dns, metadata)
+	if result := metadata.getLastResult(); result.Status != StatusFail {
+		t.Errorf("Expected the dependent check to run once its dependency recovered, got %+v", result)
+	}
+}
+
+func TestSkippedCheckKeepsFailing(t *testing.T) {
+	dns, metadata := newDependentChecks()
+	withChecks(t, metadata, dns)
+
+	metadata.healthy = false
+	evalSequentially(dns, metadata)
+	dns.healthy = false
+	evalSequentially(dns, metadata)
+
+	if result := metadata.getLastResult(); result.Status != StatusSkipped {
+		t.Fatalf("Expected the dependent check to be skipped, got %+v", result)
+	}
+	if severity := metadata.getDetail().severity(); severity != StatusFail {
+		t.Errorf("Expected the skipped unhealthy check to keep failing, got %s", severity)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(readyz).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz?exclude=dns", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if expected := "[-]metadata failed (skipped): blocked by failing dependency dns"; !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("readyz returned unexpected body: got %q want it to contain %q", rr.Body.String(), expected)
+	}
+}
+
+func TestNonCriticalDependencyDoesNotBlock(t *testing.T) {
+	dns, metadata := newDependentChecks()
+	dns.nonCritical = true
+	withChecks(t, metadata, dns)
+
+	metadata.healthy = false
+	evalSequentially(dns, metadata)
+	if getNodeHealth() {
+		t.Fatalf("Expected the failing critical check to make the node unhealthy")
+	}
+
+	dns.healthy = false
+	evalSequentially(dns, metadata)
+	if result := metadata.getLastResult(); result.Status != StatusFail {
+		t.Errorf("Expected a failing non-critical dependency not to skip the check, got %+v", result)
+	}
+	if getNodeHealth() {
+		t.Errorf("Expected the node to stay unhealthy while the critical check fails")
+	}
+}
+
+func TestSchedulerSkipsDependents(t *testing.T) {
+	defer setPollerStaleAfter(0)
+	defer setNodeHealth(true)
+	dns, metadata := newDependentChecks()
+	dns.healthy = false
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s := &scheduler{interval: 10 * time.Millisecond}
+	s.run(ctx, []CheckInterface{metadata, dns})
+
+	if result := metadata.getLastResult(); result.Status != StatusSkipped || result.Message != "blocked by failing dependency dns" {
+		t.Errorf("Expected the scheduler to skip the dependent check, got %+v", result)
+	}
+	if !metadata.getStatus() {
+		t.Errorf("Expected the skipped check to keep its health")
+	}
+}
+
+func TestSchedulerBlamesDependencyFailingFirst(t *testing.T) {
+	defer setNodeHealth(true)
+	dns, metadata := newDependentChecks()
+	dns.healthy = false
+	metadata.healthy = false
+	checks := []CheckInterface{metadata, dns}
+
+	// only the dependent check is scheduled, so it runs before dns was ever evaluated
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s := &scheduler{interval: 10 * time.Millisecond}
+	s.loop(ctx, metadata, checks)
+
+	if result := dns.getLastResult(); result.Status != StatusFail {
+		t.Errorf("Expected dns to be evaluated on demand, got %+v", result)
+	}
+	if result := metadata.getLastResult(); result.Status != StatusSkipped || result.Message != "blocked by failing dependency dns" {
+		t.Errorf("Expected the dependent check to be skipped, got %+v", result)
+	}
+	if !metadata.getStatus() || metadata.getDetail().severity() == StatusFail {
+		t.Errorf("Expected the dependent check never to fail for the outage of dns")
+	}
+}
+
+func TestDependencyErrors(t *testing.T) {
+	a := NewFakeCheck()
+	a.name = "a"
+	a.dependsOn = []string{"b"}
+	b := NewFakeCheck()
+	b.name = "b"
+	b.dependsOn = []string{"a", "c"}
+
+	errs := dependencyErrors([]CheckInterface{a, b})
+	if len(errs) != 2 {
+		t.Fatalf("Expected an unknown dependency and a cycle, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), `unknown check "c"`) {
+		t.Errorf("unexpected error: %v", errs[0])
+	}
+	if errs[1].Error() != "dependency cycle: a -> b -> a" {
+		t.Errorf("unexpected error: %v", errs[1])
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/miekg/dns"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckDNS is a check that looks for a healthy response from the internal DNS zone of Rancher, or any
+// other names configured for it
+type CheckDNS struct {
+	Check
+	queries []dnsQuery
+	// servers are queried instead of the nameservers of resolvConf when set, as host:port
+	servers    []string
+	resolvConf string
+	// protocol is udp, tcp or both, truncated udp answers are always retried over tcp
+	protocol string
+	// ednsBufferSize advertises an EDNS0 UDP buffer size when set, so larger answers fit without truncation
+	ednsBufferSize uint16
+	// latencyThreshold fails the check when a query takes longer, zero disables it
+	latencyThreshold time.Duration
+}
+
+// dnsQuery is a single name and record type the DNS check resolves, with what the answer must contain
+type dnsQuery struct {
+	name  string
+	qtype uint16
+	// expect lists answers that must all be present, e.g. addresses for A or target:port for SRV
+	expect []string
+	// minAnswers is the minimum number of answers of qtype
+	minAnswers int
+}
+
+// dnsTypes are the record types the DNS check can query
+var dnsTypes = map[string]uint16{
+	"A":     dns.TypeA,
+	"AAAA":  dns.TypeAAAA,
+	"CNAME": dns.TypeCNAME,
+	"SRV":   dns.TypeSRV,
+	"TXT":   dns.TypeTXT,
+}
+
+func NewCheckDNS(cfg Config) *CheckDNS {
+	return &CheckDNS{
+		Check: Check{
+			name:          "CheckDNS",
+			checkType:     "dns",
+			description:   "A check for the DNS Service",
+			currentStatus: true,
+			timeout:       time.Duration(cfg.checkTimeout) * time.Second,
+			interval:      time.Duration(cfg.pollInterval) * time.Second,
+			rise:          cfg.checkRise,
+			fall:          cfg.checkFall,
+			cfg:           cfg,
+		},
+		queries:    []dnsQuery{{name: "rancher-metadata.rancher.internal.", qtype: dns.TypeA, minAnswers: 1}},
+		resolvConf: "/etc/resolv.conf",
+		protocol:   "udp",
+	}
+}
+
+// dnsServerResult is the outcome of every query against one nameserver
+type dnsServerResult struct {
+	server   string
+	slowest  time.Duration
+	failures []string
+	// latency is the slowest exchange per protocol
+	latency map[string]time.Duration
+}
+
+// String summarizes the latency per protocol, e.g. 10.42.0.2:53 (udp 2ms, tcp 5ms)
+func (r dnsServerResult) String() string {
+	latencies := []string{}
+	for _, protocol := range []string{"udp", "tcp"} {
+		if latency, ok := r.latency[protocol]; ok {
+			latencies = append(latencies, protocol+" "+latency.Truncate(time.Microsecond).String())
+		}
+	}
+	return fmt.Sprintf("%s (%s)", r.server, strings.Join(latencies, ", "))
+}
+
+// eval runs every query against every nameserver in parallel, so a single broken resolver of the host
+// fails the check and shows up in its message
+func (c *CheckDNS) eval(ctx context.Context) CheckResult {
+	logrus.Infof("Evaluating check %s", c.name)
+
+	// borrowing from https://godoc.org/github.com/miekg/dns#example-MX
+	config, err := dns.ClientConfigFromFile(c.resolvConf)
+	if err != nil {
+		if len(c.servers) == 0 {
+			return failResult(fmt.Errorf("reading %s: %v", c.resolvConf, err))
+		}
+		// the configured servers don't need resolv.conf, names are queried without search domains
+		logrus.Debugf("Reading %s: %v", c.resolvConf, err)
+		config = &dns.ClientConfig{Ndots: 1}
+	}
+	servers := c.servers
+	if len(servers) == 0 {
+		for _, server := range config.Servers {
+			servers = append(servers, net.JoinHostPort(server, config.Port))
+		}
+	}
+	if len(servers) == 0 {
+		return failResult(fmt.Errorf("no nameserver found in %s", c.resolvConf))
+	}
+
+	results := make([]dnsServerResult, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			results[i] = c.queryServer(ctx, config, server)
+		}(i, server)
+	}
+	wg.Wait()
+
+	var slowest time.Duration
+	failed := false
+	summaries := []string{}
+	for _, server := range results {
+		if server.slowest > slowest {
+			slowest = server.slowest
+		}
+		for protocol, latency := range server.latency {
+			promDNSLatency.WithLabelValues(c.name, server.server, protocol).Set(latency.Seconds())
+		}
+		if len(server.failures) > 0 {
+			failed = true
+			summaries = append(summaries, server.server+": "+strings.Join(server.failures, ", "))
+		} else {
+			summaries = append(summaries, server.String())
+		}
+	}
+
+	var result CheckResult
+	if failed {
+		result = failResult(errors.New(strings.Join(summaries, "; ")))
+	} else {
+		names := []string{}
+		for _, query := range c.queries {
+			names = append(names, query.String())
+		}
+		result = passResult(fmt.Sprintf("resolved %s on %s", strings.Join(names, ", "), strings.Join(summaries, ", ")))
+	}
+	result.ObservedValue = slowest.Seconds() * 1000
+	result.ObservedUnit = "ms"
+
+	return result
+}
+
+// queryServer runs every query against a single nameserver, over both protocols when configured so
+// a host whose firewall blocks TCP/53 is caught before an answer is large enough to need it
+func (c *CheckDNS) queryServer(ctx context.Context, config *dns.ClientConfig, server string) dnsServerResult {
+	result := dnsServerResult{server: server, latency: map[string]time.Duration{}}
+	protocols := []string{c.protocol}
+	if c.protocol == "both" {
+		protocols = []string{"udp", "tcp"}
+	}
+	for _, query := range c.queries {
+		for _, protocol := range protocols {
+			rtt, err := c.resolve(ctx, server, config, query, protocol, result.latency)
+			if rtt > result.slowest {
+				result.slowest = rtt
+			}
+			if err != nil {
+				result.failures = append(result.failures, err.Error())
+			} else if c.latencyThreshold > 0 && rtt > c.latencyThreshold {
+				result.failures = append(result.failures, fmt.Sprintf("%s over %s took %s, above the threshold of %s",
+					query, protocol, rtt.Truncate(time.Millisecond), c.latencyThreshold))
+			}
+		}
+	}
+	return result
+}
+
+// exchange sends m to server over protocol, retrying over tcp when the udp answer is truncated as
+// its answer section is incomplete. The slowest exchange per protocol is recorded in latency.
+func (c *CheckDNS) exchange(ctx context.Context, server string, m *dns.Msg, protocol string, latency map[string]time.Duration) (*dns.Msg, time.Duration, error) {
+	dnsClient := &dns.Client{Net: protocol}
+	r, rtt, err := dnsClient.ExchangeContext(ctx, m, server)
+	if rtt > latency[protocol] {
+		latency[protocol] = rtt
+	}
+	if protocol == "udp" && r != nil && r.Truncated {
+		logrus.Debugf("Answer to %s from %s truncated, retrying over tcp", m.Question[0].Name, server)
+		r, tcpRTT, err := c.exchange(ctx, server, m, "tcp", latency)
+		if err != nil {
+			err = fmt.Errorf("retrying truncated answer over tcp: %v", err)
+		}
+		return r, rtt + tcpRTT, err
+	}
+	return r, rtt, err
+}
+
+// resolve sends query to server and checks the answer against its expectations. Like the system
+// resolver, a name that isn't fully qualified is tried with the search domains of config according to
+// its ndots option, moving on to the next candidate on NXDOMAIN or an empty answer.
+func (c *CheckDNS) resolve(ctx context.Context, server string, config *dns.ClientConfig, query dnsQuery, protocol string, latency map[string]time.Duration) (time.Duration, error) {
+	var total time.Duration
+	candidates := config.NameList(query.name)
+	for i, name := range candidates {
+		m := new(dns.Msg)
+		m.SetQuestion(name, query.qtype)
+		m.RecursionDesired = true
+		if c.ednsBufferSize > 0 {
+			m.SetEdns0(c.ednsBufferSize, false)
+		}
+		r, rtt, err := c.exchange(ctx, server, m, protocol, latency)
+		total += rtt
+		if err != nil {
+			return total, fmt.Errorf("%s over %s: %v", query, protocol, err)
+		}
+		last := i == len(candidates)-1
+		if !last && (r.Rcode == dns.RcodeNameError || (r.Rcode == dns.RcodeSuccess && !query.answered(r.Answer))) {
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			return total, fmt.Errorf("%s over %s: DNS query returned %s", query, protocol, dns.RcodeToString[r.Rcode])
+		}
+		return total, query.check(r.Answer)
+	}
+	return total, fmt.Errorf("%s: no name to query", query)
+}
+
+// answered reports whether answer holds any record of the queried type
+func (q dnsQuery) answered(answer []dns.RR) bool {
+	for _, rr := range answer {
+		if rr.Header().Rrtype == q.qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// check verifies the answer section of a response, only records of the queried type count so the
+// CNAMEs leading to an A record don't satisfy an A query
+func (q dnsQuery) check(answer []dns.RR) error {
+	answers := map[string]bool{}
+	count := 0
+	for _, rr := range answer {
+		if rr.Header().Rrtype != q.qtype {
+			continue
+		}
+		count++
+		answers[dnsAnswer(rr)] = true
+	}
+	if count < q.minAnswers {
+		return fmt.Errorf("%s: got %d answers, expected at least %d", q, count, q.minAnswers)
+	}
+	for _, expected := range q.expect {
+		if !answers[q.normalize(expected)] {
+			return fmt.Errorf("%s: answer %s missing", q, expected)
+		}
+	}
+	return nil
+}
+
+func (q dnsQuery) String() string {
+	return q.name + " " + dns.TypeToString[q.qtype]
+}
+
+// dnsAnswer renders a record the way expected answers are written in the configuration
+func dnsAnswer(rr dns.RR) string {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.String()
+	case *dns.AAAA:
+		return rr.AAAA.String()
+	case *dns.CNAME:
+		return dnsName(rr.Target)
+	case *dns.SRV:
+		return dnsName(rr.Target) + ":" + strconv.Itoa(int(rr.Port))
+	case *dns.TXT:
+		return strings.Join(rr.Txt, "")
+	}
+	return rr.String()
+}
+
+// normalize brings an expected answer into the form of dnsAnswer, so addresses and names compare
+// regardless of notation, case and trailing dot
+func (q dnsQuery) normalize(expected string) string {
+	switch q.qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		if ip := net.ParseIP(expected); ip != nil {
+			return ip.String()
+		}
+	case dns.TypeCNAME:
+		return dnsName(expected)
+	case dns.TypeSRV:
+		if i := strings.LastIndex(expected, ":"); i >= 0 {
+			return dnsName(expected[:i]) + expected[i:]
+		}
+	}
+	return expected
+}
+
+func dnsName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+type dnsQueryParams struct {
+	Name       string   `yaml:"name"`
+	Type       string   `yaml:"type"`
+	Expect     []string `yaml:"expect"`
+	MinAnswers *int     `yaml:"min_answers"`
+}
+
+type dnsParams struct {
+	Query            string           `yaml:"query"`
+	Queries          []dnsQueryParams `yaml:"queries"`
+	LatencyThreshold string           `yaml:"latency_threshold"`
+	Servers          []string         `yaml:"servers"`
+	ResolvConf       string           `yaml:"resolv_conf"`
+	Protocol         string           `yaml:"protocol"`
+	EDNSBufferSize   int              `yaml:"edns_buffer_size"`
+}
+
+func newCheckDNSFromConfig(cfg Config, cc CheckConfig) (CheckInterface, error) {
+	check := NewCheckDNS(cfg)
+	var params dnsParams
+	if err := cc.decodeParams(&params); err != nil {
+		return nil, err
+	}
+	if params.Query != "" && len(params.Queries) > 0 {
+		return nil, fmt.Errorf("params: use either query or queries")
+	}
+	if params.Query != "" {
+		params.Queries = []dnsQueryParams{{Name: params.Query}}
+	}
+	if len(params.Queries) > 0 {
+		check.queries = nil
+	}
+	for i, qp := range params.Queries {
+		query, err := qp.query()
+		if err != nil {
+			return nil, fmt.Errorf("params: queries %d: %v", i, err)
+		}
+		check.queries = append(check.queries, query)
+	}
+	if params.LatencyThreshold != "" {
+		threshold, err := time.ParseDuration(params.LatencyThreshold)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("params: latency_threshold: %q is not a positive duration such as 200ms", params.LatencyThreshold)
+		}
+		check.latencyThreshold = threshold
+	}
+	for _, server := range params.Servers {
+		address, err := dnsServerAddress(server)
+		if err != nil {
+			return nil, fmt.Errorf("params: servers: %v", err)
+		}
+		check.servers = append(check.servers, address)
+	}
+	if params.ResolvConf != "" {
+		check.resolvConf = params.ResolvConf
+	}
+	switch params.Protocol {
+	case "":
+	case "udp", "tcp", "both":
+		check.protocol = params.Protocol
+	default:
+		return nil, fmt.Errorf("params: protocol: %q is not one of udp, tcp or both", params.Protocol)
+	}
+	if params.EDNSBufferSize != 0 {
+		if params.EDNSBufferSize < dns.MinMsgSize || params.EDNSBufferSize > dns.MaxMsgSize {
+			return nil, fmt.Errorf("params: edns_buffer_size: %d is not between %d and %d", params.EDNSBufferSize, dns.MinMsgSize, dns.MaxMsgSize)
+		}
+		check.ednsBufferSize = uint16(params.EDNSBufferSize)
+	}
+	if err := cc.apply(&check.Check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+func (p dnsQueryParams) query() (dnsQuery, error) {
+	if p.Name == "" {
+		return dnsQuery{}, fmt.Errorf("name is required")
+	}
+	if p.Type == "" {
+		p.Type = "A"
+	}
+	qtype, ok := dnsTypes[strings.ToUpper(p.Type)]
+	if !ok {
+		return dnsQuery{}, fmt.Errorf("type: %q is not one of A, AAAA, CNAME, SRV or TXT", p.Type)
+	}
+	// an empty answer only passes when min_answers is explicitly set to 0
+	query := dnsQuery{name: p.Name, qtype: qtype, expect: p.Expect, minAnswers: 1}
+	if p.MinAnswers != nil {
+		if *p.MinAnswers < 0 {
+			return dnsQuery{}, fmt.Errorf("min_answers must not be negative")
+		}
+		query.minAnswers = *p.MinAnswers
+	}
+	return query, nil
+}
+
+// dnsServerAddress turns a nameserver given as an IP address, optionally with a port, into host:port
+func dnsServerAddress(server string) (string, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = server, "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("%q is not an IP address with an optional port", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startDNSServer serves handler over UDP and TCP on a random local port and returns its address
+func startDNSServer(t *testing.T, handler dns.HandlerFunc) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var servers []*dns.Server
+	for _, server := range []*dns.Server{{PacketConn: conn, Handler: handler}, {Listener: listener, Handler: handler}} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go server.ActivateAndServe()
+		<-started
+		servers = append(servers, server)
+	}
+	return conn.LocalAddr().String(), func() {
+		for _, server := range servers {
+			server.Shutdown()
+		}
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestDNSQueryCheck(t *testing.T) {
+	answer := []dns.RR{
+		mustRR(t, "www.example.com. 60 IN CNAME web.example.com."),
+		mustRR(t, "web.example.com. 60 IN A 10.0.0.1"),
+		mustRR(t, "web.example.com. 60 IN A 10.0.0.2"),
+	}
+	for _, test := range []struct {
+		query    dnsQuery
+		contains string
+	}{
+		{dnsQuery{name: "www.example.com", qtype: dns.TypeA, minAnswers: 2}, ""},
+		{dnsQuery{name: "www.example.com", qtype: dns.TypeA, minAnswers: 3}, "got 2 answers, expected at least 3"},
+		{dnsQuery{name: "www.example.com", qtype: dns.TypeA, expect: []string{"10.0.0.2"}}, ""},
+		{dnsQuery{name: "www.example.com", qtype: dns.TypeA, expect: []string{"10.0.0.3"}}, "answer 10.0.0.3 missing"},
+		{dnsQuery{name: "www.example.com", qtype: dns.TypeCNAME, expect: []string{"WEB.example.com"}}, ""},
+		{dnsQuery{name: "www.example.com", qtype: dns.TypeTXT, minAnswers: 1}, "got 0 answers"},
+	} {
+		err := test.query.check(answer)
+		if (err == nil) != (test.contains == "") || (err != nil && !strings.Contains(err.Error(), test.contains)) {
+			t.Errorf("%s %v: got %v, want an error containing %q", test.query, test.query.expect, err, test.contains)
+		}
+	}
+
+	srv := dnsQuery{name: "_http._tcp.example.com", qtype: dns.TypeSRV, expect: []string{"web.example.com.:8080"}}
+	if err := srv.check([]dns.RR{mustRR(t, "_http._tcp.example.com. 60 IN SRV 10 5 8080 web.example.com.")}); err != nil {
+		t.Errorf("unexpected SRV error: %v", err)
+	}
+}
+
+// rancherDNS answers rancher-metadata.rancher.internal. and NXDOMAIN for anything else
+func rancherDNS(t *testing.T) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "rancher-metadata.rancher.internal." {
+			m.Answer = append(m.Answer, mustRR(t, "rancher-metadata.rancher.internal. 1 IN A 169.254.169.250"))
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func TestCheckDNSResolve(t *testing.T) {
+	addr, stop := startDNSServer(t, rancherDNS(t))
+	defer stop()
+
+	check := NewCheckDNS(Config{})
+	config := &dns.ClientConfig{Ndots: 1}
+	if _, err := check.resolve(context.Background(), addr, config, check.queries[0], "udp", map[string]time.Duration{}); err != nil {
+		t.Errorf("Expected the default query to resolve, got %v", err)
+	}
+	missing := dnsQuery{name: "missing.example.com", qtype: dns.TypeA}
+	if _, err := check.resolve(context.Background(), addr, config, missing, "udp", map[string]time.Duration{}); err == nil || !strings.Contains(err.Error(), "NXDOMAIN") {
+		t.Errorf("Expected NXDOMAIN, got %v", err)
+	}
+
+	config.Search = []string{"example.com", "rancher.internal"}
+	short := dnsQuery{name: "rancher-metadata", qtype: dns.TypeA, minAnswers: 1}
+	if _, err := check.resolve(context.Background(), addr, config, short, "udp", map[string]time.Duration{}); err != nil {
+		t.Errorf("Expected the search domains to be applied, got %v", err)
+	}
+}
+
+func TestCheckDNSEveryServer(t *testing.T) {
+	good, stopGood := startDNSServer(t, rancherDNS(t))
+	defer stopGood()
+	broken, stopBroken := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+	defer stopBroken()
+
+	check := NewCheckDNS(Config{})
+	check.resolvConf = "/no/such/resolv.conf"
+	check.servers = []string{good}
+	if result := check.eval(context.Background()); result.Status != StatusPass {
+		t.Errorf("Expected a pass without resolv.conf when servers are configured, got %q", result.Message)
+	}
+
+	check.servers = []string{good, broken}
+	result := check.eval(context.Background())
+	if result.Status != StatusFail || !strings.Contains(result.Message, good+" (udp ") ||
+		!strings.Contains(result.Message, broken+": rancher-metadata.rancher.internal. A over udp: DNS query returned SERVFAIL") {
+		t.Errorf("Expected a failure naming the broken server, got %s %q", result.Status, result.Message)
+	}
+}
+
+func TestCheckDNSTruncated(t *testing.T) {
+	srv := []string{
+		"_http._tcp.rancher.internal. 1 IN SRV 10 5 8080 web-1.rancher.internal.",
+		"_http._tcp.rancher.internal. 1 IN SRV 10 5 8080 web-2.rancher.internal.",
+	}
+	var blockTCP int32
+	addr, stop := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		switch {
+		case w.LocalAddr().Network() == "udp":
+			m.Truncated = true
+		case atomic.LoadInt32(&blockTCP) == 1:
+			m.Rcode = dns.RcodeRefused
+		default:
+			for _, rr := range srv {
+				m.Answer = append(m.Answer, mustRR(t, rr))
+			}
+		}
+		w.WriteMsg(m)
+	})
+	defer stop()
+
+	check := NewCheckDNS(Config{})
+	check.servers = []string{addr}
+	check.ednsBufferSize = 4096
+	check.queries = []dnsQuery{{name: "_http._tcp.rancher.internal.", qtype: dns.TypeSRV, minAnswers: 2}}
+	result := check.eval(context.Background())
+	if result.Status != StatusPass || !strings.Contains(result.Message, "tcp ") {
+		t.Errorf("Expected the truncated answer to be retried over tcp, got %s %q", result.Status, result.Message)
+	}
+
+	atomic.StoreInt32(&blockTCP, 1)
+	check.protocol = "both"
+	result = check.eval(context.Background())
+	if result.Status != StatusFail || !strings.Contains(result.Message, "over tcp: DNS query returned REFUSED") {
+		t.Errorf("Expected a failure over tcp, got %s %q", result.Status, result.Message)
+	}
+}
+
+func TestCheckDNSTimeout(t *testing.T) {
+	// a nameserver that never answers keeps eval running until the deadline of the check
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	check := NewCheckDNS(Config{})
+	check.servers = []string{conn.LocalAddr().String()}
+	check.timeout = 50 * time.Millisecond
+	runCheck(context.Background(), check)
+	// the read deadline of the client may fire just before the one of the check
+	if result := check.getLastResult(); result.Status != StatusFail {
+		t.Errorf("Expected the check to fail once its deadline passed, got %+v", result)
+	}
+}
+
+func TestCheckDNSResolvConf(t *testing.T) {
+	check := NewCheckDNS(Config{})
+	check.resolvConf = "/no/such/resolv.conf"
+	if result := check.eval(context.Background()); result.Status != StatusFail || !strings.Contains(result.Message, "reading /no/such/resolv.conf") {
+		t.Errorf("Expected a failure reading resolv.conf, got %s %q", result.Status, result.Message)
+	}
+
+	check.resolvConf = writeConfigFile(t, "search rancher.internal\noptions ndots:2\n")
+	defer os.Remove(check.resolvConf)
+	if result := check.eval(context.Background()); result.Status != StatusFail || !strings.Contains(result.Message, "no nameserver found") {
+		t.Errorf("Expected a failure without nameservers, got %s %q", result.Status, result.Message)
+	}
+}
+
+func TestCheckDNSConfig(t *testing.T) {
+	path := writeConfigFile(t, `
+checks:
+  - type: dns
+    params:
+      latency_threshold: 200ms
+      queries:
+        - name: rancher-metadata.rancher.internal
+          expect: [169.254.169.250]
+        - name: _http._tcp.example.com
+          type: srv
+          min_answers: 2
+      servers: [10.42.0.2, "[fd00::53]:5353"]
+      protocol: both
+      edns_buffer_size: 4096
+`)
+	defer os.Remove(path)
+	checks, err := loadChecks(Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := checks[0].(*CheckDNS)
+	if check.latencyThreshold != 200*time.Millisecond || len(check.queries) != 2 ||
+		check.queries[0].minAnswers != 1 || check.queries[1].qtype != dns.TypeSRV || check.queries[1].minAnswers != 2 ||
+		len(check.servers) != 2 || check.servers[0] != "10.42.0.2:53" || check.servers[1] != "[fd00::53]:5353" ||
+		check.protocol != "both" || check.ednsBufferSize != 4096 {
+		t.Errorf("DNS params not applied: %+v", check)
+	}
+
+	for params, expected := range map[string]string{
+		"{queries: [{name: example.com, type: MX}]}":           "is not one of A, AAAA",
+		"{queries: [{type: A}]}":                               "name is required",
+		"{query: example.com, queries: [{name: example.com}]}": "either query or queries",
+		"{latency_threshold: fast}":                            "latency_threshold",
+		"{servers: [dns.example.com]}":                         "not an IP address",
+		"{protocol: quic}":                                     "protocol",
+		"{edns_buffer_size: 100}":                              "edns_buffer_size",
+	} {
+		path := writeConfigFile(t, "checks: [{type: dns, params: "+params+"}]")
+		_, err := loadChecks(Config{}, path)
+		os.Remove(path)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q for %q, got %v", expected, params, err)
+		}
+	}
+}
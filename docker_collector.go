@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/dustin/go-humanize"
+	"github.com/prometheus/client_golang/prometheus"
+	"strings"
+	"time"
+)
+
+// dockerCollector exports the Docker daemon info as metrics on every scrape, enabled with
+// ENABLE_DOCKER_COLLECTOR so a node doesn't need a separate exporter
+type dockerCollector struct {
+	timeout time.Duration
+	// daemonInfo queries the daemon, replaced in tests
+	daemonInfo func(ctx context.Context) (dockerDaemonInfo, error)
+}
+
+// dockerDaemonInfo is what the collector needs from the daemon
+type dockerDaemonInfo struct {
+	info       types.Info
+	apiVersion string
+}
+
+var (
+	dockerUpDesc = prometheus.NewDesc("cowcheck_docker_up",
+		"1 when the Docker daemon info could be read during the scrape, 0 otherwise", nil, nil)
+	dockerInfoDesc = prometheus.NewDesc("cowcheck_docker_info",
+		"Always 1, labelled with the storage driver and the versions of the Docker daemon",
+		[]string{"driver", "server_version", "api_version"}, nil)
+	dockerContainersDesc = prometheus.NewDesc("cowcheck_docker_containers",
+		"Number of containers by state", []string{"state"}, nil)
+	dockerImagesDesc = prometheus.NewDesc("cowcheck_docker_images",
+		"Number of images", nil, nil)
+	dockerPoolDesc = prometheus.NewDesc("cowcheck_docker_thin_pool_bytes",
+		"Space of the devicemapper thin pool in bytes, by pool (data or metadata) and kind (used, total or available)",
+		[]string{"pool", "kind"}, nil)
+	dockerLoopFileDesc = prometheus.NewDesc("cowcheck_docker_loop_file_bytes",
+		"Disk space allocated to the devicemapper loop files in bytes, by pool (data or metadata)",
+		[]string{"pool", "path"}, nil)
+)
+
+func newDockerCollector(cfg Config) *dockerCollector {
+	return &dockerCollector{
+		timeout:    time.Duration(cfg.checkTimeout) * time.Second,
+		daemonInfo: queryDockerDaemon,
+	}
+}
+
+func queryDockerDaemon(ctx context.Context) (dockerDaemonInfo, error) {
+	cli, err := dockerClient.NewEnvClient()
+	if err != nil {
+		return dockerDaemonInfo{}, err
+	}
+	defer cli.Close()
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return dockerDaemonInfo{}, err
+	}
+	version, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return dockerDaemonInfo{}, err
+	}
+	return dockerDaemonInfo{info: info, apiVersion: version.APIVersion}, nil
+}
+
+func (c *dockerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		dockerUpDesc, dockerInfoDesc, dockerContainersDesc, dockerImagesDesc, dockerPoolDesc, dockerLoopFileDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *dockerCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	daemon, err := c.daemonInfo(ctx)
+	if err != nil {
+		logrus.Errorf("Collecting Docker daemon info: %v", err)
+		ch <- prometheus.MustNewConstMetric(dockerUpDesc, prometheus.GaugeValue, 0)
+		return
+	}
+	info := daemon.info
+	ch <- prometheus.MustNewConstMetric(dockerUpDesc, prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(dockerInfoDesc, prometheus.GaugeValue, 1,
+		info.Driver, info.ServerVersion, daemon.apiVersion)
+	for state, count := range map[string]int{
+		"running": info.ContainersRunning,
+		"paused":  info.ContainersPaused,
+		"stopped": info.ContainersStopped,
+	} {
+		ch <- prometheus.MustNewConstMetric(dockerContainersDesc, prometheus.GaugeValue, float64(count), state)
+	}
+	ch <- prometheus.MustNewConstMetric(dockerImagesDesc, prometheus.GaugeValue, float64(info.Images))
+
+	// only devicemapper reports a thin pool, e.g. "Data Space Used" or "Metadata loop file"
+	for _, item := range info.DriverStatus {
+		fields := strings.Fields(item[0])
+		if len(fields) != 3 || (fields[0] != "Data" && fields[0] != "Metadata") {
+			continue
+		}
+		pool := strings.ToLower(fields[0])
+		switch {
+		case fields[1] == "Space" && (fields[2] == "Used" || fields[2] == "Total" || fields[2] == "Available"):
+			bytes, err := humanize.ParseBytes(item[1])
+			if err != nil {
+				logrus.Debugf("Parsing '%s' value %q: %v", item[0], item[1], err)
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(dockerPoolDesc, prometheus.GaugeValue, float64(bytes),
+				pool, strings.ToLower(fields[2]))
+		case fields[1] == "loop" && fields[2] == "file":
+			// the loop files are only visible when the Docker root dir is mounted into the container
+			usage, err := fileUsage(item[1])
+			if err != nil {
+				logrus.Debugf("Measuring %s loop file %s: %v", pool, item[1], err)
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(dockerLoopFileDesc, prometheus.GaugeValue, float64(usage), pool, item[1])
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDockerCollector(t *testing.T) {
+	collector := &dockerCollector{
+		timeout: time.Second,
+		daemonInfo: func(ctx context.Context) (dockerDaemonInfo, error) {
+			return dockerDaemonInfo{
+				info: types.Info{
+					ContainersRunning: 3,
+					ContainersPaused:  1,
+					ContainersStopped: 2,
+					Images:            7,
+					Driver:            "devicemapper",
+					ServerVersion:     "1.13.1",
+					DriverStatus: [][2]string{
+						{"Pool Name", "docker-thinpool"},
+						{"Data Space Used", "1 GB"},
+						{"Data Space Total", "10 GB"},
+						{"Data Space Available", "9 GB"},
+						{"Metadata Space Used", "10 MB"},
+						{"Data loop file", "/no/such/loop/data"},
+					},
+				},
+				apiVersion: "1.26",
+			}, nil
+		},
+	}
+
+	expected := `
+# HELP cowcheck_docker_containers Number of containers by state
+# TYPE cowcheck_docker_containers gauge
+cowcheck_docker_containers{state="paused"} 1
+cowcheck_docker_containers{state="running"} 3
+cowcheck_docker_containers{state="stopped"} 2
+# HELP cowcheck_docker_info Always 1, labelled with the storage driver and the versions of the Docker daemon
+# TYPE cowcheck_docker_info gauge
+cowcheck_docker_info{api_version="1.26",driver="devicemapper",server_version="1.13.1"} 1
+# HELP cowcheck_docker_thin_pool_bytes Space of the devicemapper thin pool in bytes, by pool (data or metadata) and kind (used, total or available)
+# TYPE cowcheck_docker_thin_pool_bytes gauge
+cowcheck_docker_thin_pool_bytes{kind="available",pool="data"} 9e+09
+cowcheck_docker_thin_pool_bytes{kind="total",pool="data"} 1e+10
+cowcheck_docker_thin_pool_bytes{kind="used",pool="data"} 1e+09
+cowcheck_docker_thin_pool_bytes{kind="used",pool="metadata"} 1e+07
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"cowcheck_docker_containers", "cowcheck_docker_info", "cowcheck_docker_thin_pool_bytes")
+	if err != nil {
+		t.Error(err)
+	}
+	// the loop file isn't there to measure, so it is left out
+	if count := testutil.CollectAndCount(collector, "cowcheck_docker_loop_file_bytes"); count != 0 {
+		t.Errorf("Expected no loop file metric, got %d", count)
+	}
+
+	collector.daemonInfo = func(ctx context.Context) (dockerDaemonInfo, error) {
+		return dockerDaemonInfo{}, errors.New("Cannot connect to the Docker daemon")
+	}
+	if up := testutil.ToFloat64(collector); up != 0 {
+		t.Errorf("Expected cowcheck_docker_up 0 when the daemon can't be reached, got %v", up)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CheckDetail is a point in time snapshot of a single check, as reported by the verbose health endpoint
+type CheckDetail struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type,omitempty"`
+	Description string     `json:"description"`
+	Healthy     bool       `json:"healthy"`
+	Critical    bool       `json:"critical"`
+	Severity    Status     `json:"severity"`
+	Status      Status     `json:"status,omitempty"`
+	Message     string     `json:"message,omitempty"`
+	Duration    string     `json:"duration,omitempty"`
+	LastEval    *time.Time `json:"last_eval,omitempty"`
+	LastFail    *time.Time `json:"last_fail,omitempty"`
+	DependsOn   []string   `json:"depends_on,omitempty"`
+	BlockedBy   []string   `json:"blocked_by,omitempty"`
+
+	ObservedValue interface{} `json:"observed_value,omitempty"`
+	ObservedUnit  string      `json:"observed_unit,omitempty"`
+}
+
+// HealthDetail is the overall verdict of the node together with the detail of every check
+type HealthDetail struct {
+	Healthy bool          `json:"healthy"`
+	Status  Status        `json:"status"`
+	Version string        `json:"version"`
+	Checks  []CheckDetail `json:"checks"`
+}
+
+func (c *Check) getDetail() CheckDetail {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	detail := CheckDetail{
+		Name:        c.name,
+		Type:        c.checkType,
+		Description: c.description,
+		Healthy:     c.currentStatus,
+		Critical:    !c.nonCritical,
+		Status:      c.lastResult.Status,
+		Message:     c.lastResult.Message,
+		DependsOn:   c.dependsOn,
+
+		ObservedValue: c.lastResult.ObservedValue,
+		ObservedUnit:  c.lastResult.ObservedUnit,
+	}
+	if !c.lastEval.IsZero() {
+		lastEval := c.lastEval
+		detail.LastEval = &lastEval
+		detail.Duration = c.lastResult.Duration.String()
+	}
+	if !c.lastFail.IsZero() {
+		lastFail := c.lastFail
+		detail.LastFail = &lastFail
+	}
+	detail.Severity = detail.severity()
+	return detail
+}
+
+// severity maps a check onto pass/warn/fail for the overall verdict. Only an unhealthy critical check
+// fails, an unhealthy non-critical check or a healthy check whose last result was not a pass, e.g. it is
+// failing but hasn't reached its fall threshold yet, is a warning. A check skipped because of a failing
+// dependency keeps failing if it was already unhealthy, otherwise it is left to that dependency and
+// reported as skipped.
+func (d CheckDetail) severity() Status {
+	switch {
+	case !d.Healthy && d.Critical:
+		return StatusFail
+	case d.Status == StatusSkipped:
+		return StatusSkipped
+	case !d.Healthy, d.Status == StatusWarn, d.Status == StatusFail:
+		return StatusWarn
+	}
+	return StatusPass
+}
+
+func buildHealthDetail(checks []CheckInterface) HealthDetail {
+	detail := HealthDetail{
+		Healthy: getNodeHealth(),
+		Status:  StatusPass,
+		Version: VERSION,
+		Checks:  []CheckDetail{},
+	}
+	for _, check := range checks {
+		checkDetail := check.getDetail()
+		if checkDetail.Status == StatusSkipped {
+			checkDetail.BlockedBy = blockedBy(check, checks)
+		}
+		if checkDetail.Severity == StatusWarn || checkDetail.Severity == StatusSkipped {
+			detail.Status = StatusWarn
+		}
+		detail.Checks = append(detail.Checks, checkDetail)
+	}
+	if !detail.Healthy {
+		detail.Status = StatusFail
+	}
+	return detail
+}
+
+func findCheck(checks []CheckInterface, name string) CheckInterface {
+	for _, check := range checks {
+		if check.getName() == name {
+			return check
+		}
+	}
+	return nil
+}
+
+// checkStateByName serves /health/<name> with the status and detail of a single check
+func checkStateByName(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/health/")
+	check := findCheck(checkSlice, name)
+	if check == nil {
+		http.Error(w, "Unknown check "+name, http.StatusNotFound)
+		return
+	}
+
+	checkDetail := check.getDetail()
+	if wantsHealthJSON(r) {
+		writeHealthJSON(w, HealthDetail{
+			Healthy: checkDetail.Healthy,
+			Status:  checkDetail.Severity,
+			Version: VERSION,
+			Checks:  []CheckDetail{checkDetail},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if checkDetail.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(checkDetail); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// wantsDetail reports whether the client asked for the JSON detail, either with ?verbose or an Accept header
+func wantsDetail(r *http.Request) bool {
+	if _, ok := r.URL.Query()["verbose"]; ok {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeHealthDetail(w http.ResponseWriter, detail HealthDetail) {
+	w.Header().Set("Content-Type", "application/json")
+	if detail.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(detail); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// healthJSONMediaType is the media type of the IETF "Health Check Response Format for HTTP APIs" draft
+const healthJSONMediaType = "application/health+json"
+
+// HealthJSON is the top level document of the application/health+json format
+type HealthJSON struct {
+	Status  Status                       `json:"status"`
+	Version string                       `json:"version,omitempty"`
+	Output  string                       `json:"output,omitempty"`
+	Checks  map[string][]HealthJSONCheck `json:"checks,omitempty"`
+}
+
+// HealthJSONCheck is a single entry of the checks map of the application/health+json format
+type HealthJSONCheck struct {
+	ComponentID   string      `json:"componentId"`
+	Status        Status      `json:"status"`
+	Time          string      `json:"time,omitempty"`
+	Output        string      `json:"output,omitempty"`
+	ObservedValue interface{} `json:"observedValue,omitempty"`
+	ObservedUnit  string      `json:"observedUnit,omitempty"`
+}
+
+func wantsHealthJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), healthJSONMediaType)
+}
+
+func buildHealthJSON(detail HealthDetail) HealthJSON {
+	doc := HealthJSON{
+		Status:  StatusPass,
+		Version: detail.Version,
+		Checks:  map[string][]HealthJSONCheck{},
+	}
+	var failures []string
+	for _, check := range detail.Checks {
+		entry := HealthJSONCheck{
+			ComponentID:   check.Name,
+			Status:        check.Severity,
+			ObservedValue: check.ObservedValue,
+			ObservedUnit:  check.ObservedUnit,
+		}
+		if entry.Status == StatusSkipped {
+			// the format only knows pass, warn and fail
+			entry.Status = StatusWarn
+		}
+		if check.LastEval != nil {
+			entry.Time = check.LastEval.Format(time.RFC3339)
+		}
+		if entry.Status != StatusPass {
+			entry.Output = check.Message
+			if doc.Status == StatusPass {
+				doc.Status = StatusWarn
+			}
+		}
+		if entry.Status == StatusFail {
+			failures = append(failures, check.Name+": "+check.Message)
+		}
+		doc.Checks[check.Name] = append(doc.Checks[check.Name], entry)
+	}
+	if !detail.Healthy {
+		doc.Status = StatusFail
+		doc.Output = strings.Join(failures, "; ")
+	}
+	return doc
+}
+
+func writeHealthJSON(w http.ResponseWriter, detail HealthDetail) {
+	w.Header().Set("Content-Type", healthJSONMediaType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if detail.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(buildHealthJSON(detail)); err != nil {
+		logrus.Error(err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealthDetail(t *testing.T) {
+	failing := NewToggleCheck()
+	failing.healthy = false
+	withChecks(t, NewFakeCheck(), failing)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/health?verbose", nil),
+		func() *http.Request {
+			r := httptest.NewRequest("GET", "/health", nil)
+			r.Header.Set("Accept", "application/json")
+			return r
+		}(),
+	} {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(checkState).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("handler returned wrong content type: got %v", ct)
+		}
+
+		var detail HealthDetail
+		if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+			t.Fatal(err)
+		}
+		if detail.Healthy || detail.Version != VERSION || len(detail.Checks) != 2 {
+			t.Errorf("unexpected health detail: %+v", detail)
+		}
+		toggle := detail.Checks[1]
+		if toggle.Name != "ToggleCheck" || toggle.Healthy || toggle.Status != StatusFail ||
+			toggle.Message != "toggled off" || toggle.LastEval == nil || toggle.LastFail == nil {
+			t.Errorf("unexpected check detail: %+v", toggle)
+		}
+	}
+}
+
+func TestHealthJSON(t *testing.T) {
+	flapping := NewToggleCheck()
+	flapping.fall = 2
+	flapping.healthy = false
+	withChecks(t, NewFakeCheck(), flapping)
+
+	req := httptest.NewRequest("GET", "/health", nil)
+	req.Header.Set("Accept", "application/health+json")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(checkState).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/health+json" {
+		t.Errorf("handler returned wrong content type: got %v", ct)
+	}
+
+	var doc HealthJSON
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Status != StatusWarn {
+		t.Errorf("Expected overall status %s below the fall threshold, got %s", StatusWarn, doc.Status)
+	}
+	if entries := doc.Checks["FakeCheck"]; len(entries) != 1 || entries[0].Status != StatusPass {
+		t.Errorf("unexpected FakeCheck entry: %+v", entries)
+	}
+	if entries := doc.Checks["ToggleCheck"]; len(entries) != 1 || entries[0].Status != StatusWarn || entries[0].Output != "toggled off" {
+		t.Errorf("unexpected ToggleCheck entry: %+v", entries)
+	}
+
+	evalChecks(context.Background(), checkSlice)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(checkState).ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	doc = HealthJSON{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Status != StatusFail || doc.Checks["ToggleCheck"][0].Status != StatusFail {
+		t.Errorf("Expected overall and check status %s, got %+v", StatusFail, doc)
+	}
+}
+
+func TestCheckStateByName(t *testing.T) {
+	failing := NewToggleCheck()
+	failing.healthy = false
+	withChecks(t, NewFakeCheck(), failing)
+
+	for path, expected := range map[string]int{
+		"/health/FakeCheck":   http.StatusOK,
+		"/health/ToggleCheck": http.StatusServiceUnavailable,
+		"/health/NoSuchCheck": http.StatusNotFound,
+	} {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(checkStateByName).ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != expected {
+			t.Errorf("%s returned wrong status code: got %v want %v", path, rr.Code, expected)
+		}
+		if expected == http.StatusNotFound {
+			continue
+		}
+		var detail CheckDetail
+		if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+			t.Fatal(err)
+		}
+		if "/health/"+detail.Name != path {
+			t.Errorf("%s returned detail for the wrong check: %+v", path, detail)
+		}
+	}
+}
+
+func TestNonCriticalCheck(t *testing.T) {
+	failing := NewToggleCheck()
+	failing.nonCritical = true
+	failing.healthy = false
+	withChecks(t, NewFakeCheck(), failing)
+
+	if !getNodeHealth() {
+		t.Errorf("Expected a failing non-critical check to leave the node healthy")
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(checkState).ServeHTTP(rr, httptest.NewRequest("GET", "/health?verbose", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var detail HealthDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.Status != StatusWarn {
+		t.Errorf("Expected overall status %s, got %s", StatusWarn, detail.Status)
+	}
+	if check := detail.Checks[1]; check.Critical || check.Healthy || check.Severity != StatusWarn {
+		t.Errorf("unexpected detail for the non-critical check: %+v", check)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(readyz).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz?verbose", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("readyz returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "[-]ToggleCheck failed (non-critical): toggled off") {
+		t.Errorf("readyz did not list the non-critical failure: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHTTPBody caps how much of a response body is read for the body assertions
+const maxHTTPBody = 1 << 20
+
+// CheckHTTP is a generic check of an HTTP(S) endpoint, passing when the response has one of the
+// expected status codes and its body satisfies the configured assertions
+type CheckHTTP struct {
+	Check
+	url            string
+	method         string
+	headers        map[string]string
+	body           string
+	expectedStatus []string
+	bodyRegex      *regexp.Regexp
+	jsonAssertions map[string]string
+	client         *http.Client
+}
+
+func NewCheckHTTP(cfg Config, url string) *CheckHTTP {
+	return &CheckHTTP{
+		Check: Check{
+			name:          "CheckHTTP",
+			checkType:     "http",
+			description:   "A check for the HTTP endpoint " + url,
+			currentStatus: true,
+			timeout:       time.Duration(cfg.checkTimeout) * time.Second,
+			interval:      time.Duration(cfg.pollInterval) * time.Second,
+			rise:          cfg.checkRise,
+			fall:          cfg.checkFall,
+			cfg:           cfg,
+		},
+		url:            url,
+		method:         "GET",
+		expectedStatus: []string{"2xx"},
+		client:         &http.Client{},
+	}
+}
+
+func (c *CheckHTTP) eval(ctx context.Context) CheckResult {
+	logrus.Infof("Evaluating check %s", c.name)
+
+	var body io.Reader
+	if c.body != "" {
+		body = strings.NewReader(c.body)
+	}
+	req, err := http.NewRequest(c.method, c.url, body)
+	if err != nil {
+		return failResult(err)
+	}
+	for name, value := range c.headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+
+	start := time.Now()
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return failResult(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	if err != nil {
+		return failResult(fmt.Errorf("reading response of %s %s: %v", c.method, c.url, err))
+	}
+	elapsed := time.Since(start)
+
+	result := c.assert(resp.StatusCode, data)
+	if result.Status == StatusPass {
+		result.Message = fmt.Sprintf("%s %s returned %s", c.method, c.url, resp.Status)
+	}
+	result.ObservedValue = elapsed.Seconds() * 1000
+	result.ObservedUnit = "ms"
+	return result
+}
+
+// assert checks a response against the expected status codes, the body regex and the JSON assertions
+func (c *CheckHTTP) assert(statusCode int, body []byte) CheckResult {
+	if !statusMatches(c.expectedStatus, statusCode) {
+		return failResult(fmt.Errorf("%s %s returned status %d, expected %s",
+			c.method, c.url, statusCode, strings.Join(c.expectedStatus, ", ")))
+	}
+	if c.bodyRegex != nil && !c.bodyRegex.Match(body) {
+		return failResult(fmt.Errorf("response body of %s does not match %q", c.url, c.bodyRegex))
+	}
+	if len(c.jsonAssertions) > 0 {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return failResult(fmt.Errorf("response body of %s is not JSON: %v", c.url, err))
+		}
+		paths := make([]string, 0, len(c.jsonAssertions))
+		for path := range c.jsonAssertions {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			expected := c.jsonAssertions[path]
+			value, ok := jsonPath(doc, path)
+			if !ok {
+				return failResult(fmt.Errorf("response body of %s has no %s", c.url, path))
+			}
+			if actual := fmt.Sprint(value); actual != expected {
+				return failResult(fmt.Errorf("%s of %s is %q, expected %q", path, c.url, actual, expected))
+			}
+		}
+	}
+	return passResult("")
+}
+
+// statusMatches reports whether code matches one of patterns, each either an exact code such as 204
+// or a class such as 2xx
+func statusMatches(patterns []string, code int) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "xx") {
+			if strconv.Itoa(code/100) == strings.TrimSuffix(pattern, "xx") {
+				return true
+			}
+		} else if pattern == strconv.Itoa(code) {
+			return true
+		}
+	}
+	return false
+}
+
+var statusPattern = regexp.MustCompile(`^[1-5]([0-9][0-9]|xx)$`)
+
+// expectsRedirect reports whether one of patterns matches a 3xx status
+func expectsRedirect(patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "3") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPath looks up a dotted path such as data.items.0.state in a decoded JSON document, numeric
+// segments index into arrays
+func jsonPath(doc interface{}, path string) (interface{}, bool) {
+	value := doc
+	for _, segment := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			value = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+type httpTLSParams struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+}
+
+type httpParams struct {
+	URL             string            `yaml:"url"`
+	Method          string            `yaml:"method"`
+	Headers         map[string]string `yaml:"headers"`
+	Body            string            `yaml:"body"`
+	ExpectedStatus  []string          `yaml:"expected_status"`
+	FollowRedirects *bool             `yaml:"follow_redirects"`
+	BodyRegex       string            `yaml:"body_regex"`
+	JSON            map[string]string `yaml:"json"`
+	TLS             *httpTLSParams    `yaml:"tls"`
+}
+
+func newCheckHTTPFromConfig(cfg Config, cc CheckConfig) (CheckInterface, error) {
+	var params httpParams
+	if err := cc.decodeParams(&params); err != nil {
+		return nil, err
+	}
+	parsed, err := url.Parse(params.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("params: url: %q is not an http or https URL", params.URL)
+	}
+
+	check := NewCheckHTTP(cfg, params.URL)
+	if params.Method != "" {
+		check.method = strings.ToUpper(params.Method)
+	}
+	check.headers = params.Headers
+	check.body = params.Body
+	if len(params.ExpectedStatus) > 0 {
+		for _, pattern := range params.ExpectedStatus {
+			if !statusPattern.MatchString(pattern) {
+				return nil, fmt.Errorf("params: expected_status: %q is not a status code such as 200 or a class such as 2xx", pattern)
+			}
+		}
+		check.expectedStatus = params.ExpectedStatus
+	}
+	followRedirects := !expectsRedirect(check.expectedStatus)
+	if params.FollowRedirects != nil {
+		if *params.FollowRedirects && !followRedirects {
+			return nil, fmt.Errorf("params: follow_redirects: a 3xx expected_status can't be observed while following redirects")
+		}
+		followRedirects = *params.FollowRedirects
+	}
+	if !followRedirects {
+		check.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	if params.BodyRegex != "" {
+		if check.bodyRegex, err = regexp.Compile(params.BodyRegex); err != nil {
+			return nil, fmt.Errorf("params: body_regex: %v", err)
+		}
+	}
+	check.jsonAssertions = params.JSON
+	if params.TLS != nil {
+		tlsConfig, err := params.TLS.config()
+		if err != nil {
+			return nil, fmt.Errorf("params: tls: %v", err)
+		}
+		check.client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	}
+
+	if err := cc.apply(&check.Check); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+func (p httpTLSParams) config() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: p.InsecureSkipVerify,
+		ServerName:         p.ServerName,
+	}
+	if p.CAFile != "" {
+		pem, err := ioutil.ReadFile(p.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file: no certificates found in %s", p.CAFile)
+		}
+	}
+	if p.CertFile != "" || p.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cert_file and key_file: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
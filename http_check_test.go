@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		case "/ok":
+			w.Write([]byte(`{"state": "active", "items": [{"name": "agent", "ready": true}]}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	for _, test := range []struct {
+		params   string
+		status   Status
+		contains string
+	}{
+		{"url: " + ts.URL + "/ok", StatusFail, "returned status 406"},
+		{"url: " + ts.URL + "/ok, headers: {Accept: application/json}", StatusPass, "returned 200 OK"},
+		{"url: " + ts.URL + "/down, headers: {Accept: application/json}", StatusFail, "expected 2xx"},
+		{"url: " + ts.URL + "/down, headers: {Accept: application/json}, expected_status: [500, 3xx]", StatusPass, "returned 500"},
+		{"url: " + ts.URL + "/moved, headers: {Accept: application/json}", StatusPass, "returned 200 OK"},
+		{"url: " + ts.URL + "/moved, headers: {Accept: application/json}, expected_status: [301]", StatusPass, "returned 301"},
+		{"url: " + ts.URL + "/moved, headers: {Accept: application/json}, follow_redirects: false", StatusFail, "returned status 301, expected 2xx"},
+		{"url: " + ts.URL + "/ok, headers: {Accept: application/json}, body_regex: inactive", StatusFail, "does not match"},
+		{"url: " + ts.URL + "/ok, headers: {Accept: application/json}, json: {state: active, items.0.ready: 'true'}", StatusPass, ""},
+		{"url: " + ts.URL + "/ok, headers: {Accept: application/json}, json: {items.0.name: kubelet}", StatusFail, `is "agent", expected "kubelet"`},
+		{"url: " + ts.URL + "/ok, headers: {Accept: application/json}, json: {items.1.name: agent}", StatusFail, "has no items.1.name"},
+	} {
+		path := writeConfigFile(t, "checks: [{type: http, params: {"+test.params+"}}]")
+		checks, err := loadChecks(Config{}, path)
+		os.Remove(path)
+		if err != nil {
+			t.Errorf("%s: %v", test.params, err)
+			continue
+		}
+		result := checks[0].eval(context.Background())
+		if result.Status != test.status || !strings.Contains(result.Message, test.contains) {
+			t.Errorf("%s: got %s %q, want %s containing %q", test.params, result.Status, result.Message, test.status, test.contains)
+		}
+	}
+}
+
+func TestCheckHTTPConfigErrors(t *testing.T) {
+	for params, expected := range map[string]string{
+		"url: 169.254.169.250":                                                  "not an http or https URL",
+		"url: http://localhost, expected_status: [ok]":                          "expected_status",
+		"url: http://localhost, body_regex: '('":                                "body_regex",
+		"url: http://localhost, expected_status: [3xx], follow_redirects: true": "follow_redirects",
+		"url: http://localhost, tls: {ca_file: /no/such/ca.pem}":                "tls",
+		"url: http://localhost, tls: {cert_file: /no/such/cert.pem}":            "cert_file and key_file",
+	} {
+		path := writeConfigFile(t, "checks: [{type: http, params: {"+params+"}}]")
+		_, err := loadChecks(Config{}, path)
+		os.Remove(path)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q for %q, got %v", expected, params, err)
+		}
+	}
+}
@@ -1,182 +1,389 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/Sirupsen/logrus"
-	"github.com/davecgh/go-spew/spew"
-	"github.com/miekg/dns"
+	dockerClient "github.com/docker/docker/client"
+	"github.com/dustin/go-humanize"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urfave/cli"
 	"net/http"
-	"time"
+	"net/url"
 	"os"
+	"runtime"
+	"runtime/debug"
 	"strconv"
-	dockerClient "github.com/docker/docker/client"
-	"context"
 	"strings"
-	"github.com/dustin/go-humanize"
-  "github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
 )
 
 var VERSION = "v0.2.0"
 
-var checkSlice = []CheckInterface{}
-
+// COMMIT is the git commit cowcheck was built from, set by scripts/build
+var COMMIT = "unknown"
 
-var dataSpaceFree = uint64(0)
-var metadataSpaceFree = uint64(0)
+var checkSlice = []CheckInterface{}
 
 // Primary representation of node health
 var nodeHealth = true
+var nodeHealthMu sync.RWMutex
 
 // Generics
 type Config struct {
-	logLevel	logrus.Level
-	pollInterval	int
-	dataStorageThreshold uint64
-	metaDataStorageThreshold uint64
-	enableStorageCheck bool
+	logLevel                 logrus.Level
+	pollInterval             int
+	dataStorageThreshold     storageThreshold
+	metaDataStorageThreshold storageThreshold
+	dataStorageWarn          storageThreshold
+	metaDataStorageWarn      storageThreshold
+	inodeThreshold           storageThreshold
+	inodeWarn                storageThreshold
+	enableStorageCheck       bool
+	checkTimeout             int
+	checkRise                int
+	checkFall                int
+	pollJitter               float64
+	pollSplay                int
+	legacyNodeHealthMetric   bool
+	enableDockerCollector    bool
+	notifyURLs               []string
+	notifyTimeout            int
+	notifyRetries            int
+	notifyBackoff            int
+}
+
+// Status is the outcome of a single check evaluation
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	// StatusSkipped is recorded instead of evaluating a check while one of its dependencies fails
+	StatusSkipped Status = "skipped"
+)
+
+// CheckResult is returned by every eval() and carries why a check passed or failed
+type CheckResult struct {
+	Status   Status
+	Message  string
+	Err      error
+	Duration time.Duration
+	Time     time.Time
+
+	// ObservedValue is the measurement the check based its verdict on, if any
+	ObservedValue interface{}
+	ObservedUnit  string
+}
+
+func passResult(message string) CheckResult {
+	return CheckResult{Status: StatusPass, Message: message}
+}
+
+func warnResult(message string) CheckResult {
+	return CheckResult{Status: StatusWarn, Message: message}
+}
+
+func failResult(err error) CheckResult {
+	return CheckResult{Status: StatusFail, Message: err.Error(), Err: err}
 }
 
 // CheckInterface is a interface for Checks
 type CheckInterface interface {
-	eval() bool
-	fail() bool
+	eval(ctx context.Context) CheckResult
+	record(result CheckResult)
 	getStatus() bool
 	getName() string
+	getTimeout() time.Duration
+	getLastResult() CheckResult
+	getDetail() CheckDetail
+	getInterval() time.Duration
+	isCritical() bool
+	getDependencies() []string
+	isEnabled() bool
 }
 
 type Check struct {
 	name          string
+	checkType     string
 	description   string
 	lastEval      time.Time
 	lastFail      time.Time
+	lastResult    CheckResult
 	currentStatus bool
-	cfg			  Config
+	timeout       time.Duration
+	interval      time.Duration
+	nonCritical   bool
+	dependsOn     []string
+	cfg           Config
+	mu            sync.Mutex
+
+	// rise/fall hysteresis, like HAProxy: currentStatus only flips after this many consecutive results
+	rise                 int
+	fall                 int
+	consecutiveSuccesses int
+	consecutiveFailures  int
 }
 
-func (c *Check) eval() bool {
-	return true
+func (c *Check) eval(ctx context.Context) CheckResult {
+	return passResult("")
 }
 
-func (c *Check) fail() bool {
-	logrus.Errorf("Check %s has failed", c.name)
-	c.lastFail = time.Now()
-	c.currentStatus = false
-	return true
+// record stores the result of the latest evaluation and applies it to the rise/fall counters,
+// currentStatus only changes once enough consecutive results agree
+func (c *Check) record(result CheckResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastEval = result.Time
+	c.lastResult = result
+	if result.Status == StatusSkipped {
+		// the check wasn't evaluated, its health stays as it was until it can run again
+		logrus.Infof("Check %s skipped: %s", c.name, result.Message)
+	} else if result.Status == StatusFail {
+		logrus.WithFields(logrus.Fields{"type": "check_results"}).Errorf("Check %s has failed: %s", c.name, result.Message)
+		c.lastFail = result.Time
+		c.consecutiveSuccesses = 0
+		c.consecutiveFailures++
+		if c.currentStatus && c.consecutiveFailures >= atLeastOne(c.fall) {
+			logrus.Warnf("Check %s marked unhealthy after %d consecutive failures", c.name, c.consecutiveFailures)
+			c.currentStatus = false
+			notify(Notification{Check: c.name, OldStatus: "healthy", NewStatus: "unhealthy", Message: result.Message, Timestamp: result.Time})
+		}
+	} else {
+		c.consecutiveFailures = 0
+		c.consecutiveSuccesses++
+		if !c.currentStatus && c.consecutiveSuccesses >= atLeastOne(c.rise) {
+			logrus.Warnf("Check %s marked healthy after %d consecutive successes", c.name, c.consecutiveSuccesses)
+			c.currentStatus = true
+			notify(Notification{Check: c.name, OldStatus: "unhealthy", NewStatus: "healthy", Message: result.Message, Timestamp: result.Time})
+		}
+	}
+	c.observe(result)
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
 }
 
 func (c *Check) getStatus() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.currentStatus
 }
 
+func (c *Check) getLastResult() CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastResult
+}
+
 func (c *Check) getName() string {
 	return c.name
 }
 
-// Implemented checks
+// isCritical reports whether a failure of the check makes the node unhealthy, failures of
+// non-critical checks are only reported as warnings
+func (c *Check) isCritical() bool {
+	return !c.nonCritical
+}
 
-// CheckDNS is a check that looks for a healthy response from the internal DNS zone of Rancher
-type CheckDNS struct {
-	Check
+// isEnabled reports whether the check does any work, a disabled check always passes
+func (c *Check) isEnabled() bool {
+	return true
+}
+
+// getDependencies returns the names of the checks that must be healthy for this check to be evaluated
+func (c *Check) getDependencies() []string {
+	return c.dependsOn
 }
 
+// getInterval returns the time between evaluations of the check
+func (c *Check) getInterval() time.Duration {
+	return c.interval
+}
+
+// getTimeout returns the deadline for a single evaluation, zero means no deadline
+func (c *Check) getTimeout() time.Duration {
+	return c.timeout
+}
+
+// Implemented checks
+
 func prometheusHandler() http.Handler {
 	return promhttp.Handler()
 }
 
+var promNodeHealthy = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "cowcheck",
+	Subsystem: "node",
+	Name:      "healthy",
+	Help:      "1 when the node is healthy, 0 when one or more critical checks are unhealthy",
+})
+
+// promNodeHealth is the original node health metric with inverted semantics and a duplicated prefix,
+// only registered with LEGACY_NODE_HEALTH_METRIC until it is removed
 var promNodeHealth = prometheus.NewGauge(prometheus.GaugeOpts{
 	Namespace: "cowcheck",
 	Subsystem: "node",
 	Name:      "cowcheck_node_health",
-	Help:      "Boolean representation of overall health of node based on sum of all checks",
+	Help:      "Deprecated, use cowcheck_node_healthy. 0 when the node is healthy, 1 when it is unhealthy",
+})
+
+var promNodeWarnings = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: "cowcheck",
+	Subsystem: "node",
+	Name:      "warning_checks",
+	Help:      "Number of checks currently reporting a warning, including failing non-critical checks",
 })
 
 var promDockerDataStorageFree = prometheus.NewGauge(prometheus.GaugeOpts{
 	Namespace: "cowcheck",
 	Subsystem: "node",
 	Name:      "docker_data_storage",
-	Help:      "Amount of free Docker Data Storage space in bytes",
+	Help:      "Amount of free Docker Data Storage space in bytes, or free disk space of the Docker root dir for filesystem based storage drivers",
 })
 
-
 var promDockerMetadataStorageFree = prometheus.NewGauge(prometheus.GaugeOpts{
 	Namespace: "cowcheck",
 	Subsystem: "node",
 	Name:      "docker_metadata_storage",
-	Help:      "Amount of free Docker Metadata Storage space in bytes",
+	Help:      "Amount of free Docker Metadata Storage space in bytes, or free inodes for filesystem based storage drivers",
 })
 
-func NewCheckDNS() *CheckDNS {
-	return &CheckDNS{
-		Check{
-			name:          "CheckDNS",
-			description:   "A check for the DNS Service",
-			currentStatus: true,
-		},
-	}
-}
-
-func (c *CheckDNS) eval() bool {
-	logrus.Infof("Evaluating check %s", c.name)
-	logrus.WithFields(logrus.Fields{"before_eval": "true"}).Debug(spew.Sdump(c))
-	c.lastEval = time.Now()
-
-	// borrowing from https://godoc.org/github.com/miekg/dns#example-MX
-	config, _ := dns.ClientConfigFromFile("/etc/resolv.conf")
-	dnsClient := new(dns.Client)
-	m := new(dns.Msg)
-	m.SetQuestion("rancher-metadata.rancher.internal.", dns.TypeA)
-	m.RecursionDesired = true
-	r, _, err := dnsClient.Exchange(m, config.Servers[0]+":"+config.Port)
-	if err != nil {
-			logrus.WithFields(logrus.Fields{"type":"check_results"}).Error(err)
-		c.fail()
-		return true
-	}
-	if r.Rcode != dns.RcodeSuccess {
-		logrus.Error(err)
-		c.fail()
-		return true
-	}
-
-	logrus.WithFields(logrus.Fields{"before_eval": "false"}).Debug(spew.Sdump(c))
-	c.currentStatus = true
-	return true
-}
-
 // CheckMetadata is a check for the Metadata Service
 type CheckMetadata struct {
 	Check
+	url string
 }
 
-func NewCheckMetadata() *CheckMetadata {
+// metadataHostPath is the metadata path describing the host cowcheck runs on
+const metadataHostPath = "/latest/self/host"
+
+// metadataHost is the part of the host record the metadata check requires
+type metadataHost struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	AgentIP  string `json:"agent_ip"`
+}
+
+func NewCheckMetadata(cfg Config) *CheckMetadata {
 	return &CheckMetadata{
-		Check{
+		Check: Check{
 			name:          "CheckMetadata",
+			checkType:     "metadata",
 			description:   "A check for the CheckMetadata Service",
 			currentStatus: true,
+			timeout:       time.Duration(cfg.checkTimeout) * time.Second,
+			interval:      time.Duration(cfg.pollInterval) * time.Second,
+			rise:          cfg.checkRise,
+			fall:          cfg.checkFall,
+			cfg:           cfg,
 		},
+		url: "http://169.254.169.250",
 	}
 }
 
-func (c *CheckMetadata) eval() bool {
+// eval fetches the record of this host from the metadata service, it only passes when the service
+// answers 200 with a host record that has a uuid and a hostname
+func (c *CheckMetadata) eval(ctx context.Context) CheckResult {
 	logrus.Infof("Evaluating check %s", c.name)
-	logrus.WithFields(logrus.Fields{"before_eval": "true"}).Debug(spew.Sdump(c))
-	c.lastEval = time.Now()
-	httpClient := http.Client{Timeout: time.Duration(15 * time.Second)}
-	resp, err := httpClient.Get("http://169.254.169.250")
+	// no client timeout, ctx carries the deadline of the check so it fails with the timeout reason
+	httpClient := http.Client{}
+	hostURL := strings.TrimSuffix(c.url, "/") + metadataHostPath
+	req, err := http.NewRequest("GET", hostURL, nil)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{"type":"check_results"}).Error(err)
-		c.fail()
-		return true
+		return failResult(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return failResult(err)
 	}
 	defer resp.Body.Close()
-	logrus.WithFields(logrus.Fields{"before_eval": "false"}).Debug(spew.Sdump(c))
-	return true
+	if resp.StatusCode != http.StatusOK {
+		return failResult(fmt.Errorf("metadata service returned %s for %s", resp.Status, metadataHostPath))
+	}
+	var host metadataHost
+	if err := json.NewDecoder(resp.Body).Decode(&host); err != nil {
+		return failResult(fmt.Errorf("parsing host record from %s: %v", metadataHostPath, err))
+	}
+	if host.UUID == "" || host.Hostname == "" {
+		return failResult(fmt.Errorf("host record from %s has no uuid or hostname", metadataHostPath))
+	}
+	return passResult(fmt.Sprintf("metadata service knows host %s (%s)", host.Hostname, host.UUID))
 }
 
 // CheckStorage
 
-// CheckMetadata is a check for the Metadata Service
+// storageThreshold is a minimum amount of free space, either absolute or as a percentage of the total
+type storageThreshold struct {
+	raw string
+	// amount is in bytes, or in inodes for inode thresholds
+	amount  uint64
+	percent float64
+}
+
+// parseStorageThreshold accepts a plain number of bytes, a human readable size such as 5GiB or 500 MB,
+// or a percentage of the total such as 10%. An empty string is an unset threshold that never breaches.
+func parseStorageThreshold(raw string) (storageThreshold, error) {
+	raw = strings.TrimSpace(raw)
+	t := storageThreshold{raw: raw}
+	if raw == "" {
+		return t, nil
+	}
+	if strings.HasSuffix(raw, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(raw, "%")), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return t, fmt.Errorf("%q is not a percentage between 0%% and 100%%", raw)
+		}
+		t.percent = percent
+		return t, nil
+	}
+	bytes, err := humanize.ParseBytes(raw)
+	if err != nil {
+		return t, fmt.Errorf("%q is not a size such as 5GiB or a percentage such as 10%%", raw)
+	}
+	t.amount = bytes
+	return t, nil
+}
+
+// parseInodeThreshold accepts a plain number of inodes or a percentage of the total such as 10%. An
+// empty string is an unset threshold that never breaches.
+func parseInodeThreshold(raw string) (storageThreshold, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || strings.HasSuffix(raw, "%") {
+		return parseStorageThreshold(raw)
+	}
+	inodes, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return storageThreshold{raw: raw}, fmt.Errorf("%q is not a number of inodes or a percentage such as 10%%", raw)
+	}
+	return storageThreshold{raw: raw, amount: inodes}, nil
+}
+
+// breached reports whether free is below the threshold. Percentage thresholds need the total, when it
+// is unknown they can't be evaluated and are never breached.
+func (t storageThreshold) breached(free, total uint64) bool {
+	if t.percent > 0 {
+		return total > 0 && float64(free)*100/float64(total) < t.percent
+	}
+	return free < t.amount
+}
+
+func (t storageThreshold) String() string {
+	return t.raw
+}
+
+// CheckStorage is a check for the Docker Storage subsystem
 type CheckStorage struct {
 	Check
 }
@@ -185,65 +392,178 @@ func NewCheckStorage(cfg Config) *CheckStorage {
 	return &CheckStorage{
 		Check{
 			name:          "CheckStorage",
+			checkType:     "storage",
 			description:   "A check for the Docker Storage subsystem",
 			currentStatus: true,
-			cfg: cfg,
+			timeout:       time.Duration(cfg.checkTimeout) * time.Second,
+			interval:      time.Duration(cfg.pollInterval) * time.Second,
+			rise:          cfg.checkRise,
+			fall:          cfg.checkFall,
+			cfg:           cfg,
 		},
 	}
 }
 
-func (c *CheckStorage) eval() bool {
+func (c *CheckStorage) isEnabled() bool {
+	return c.cfg.enableStorageCheck
+}
+
+func (c *CheckStorage) eval(ctx context.Context) CheckResult {
 	logrus.Infof("Evaluating check %s", c.name)
-	logrus.WithFields(logrus.Fields{"before_eval": "true"}).Debug(spew.Sdump(c))
 
-	if c.cfg.enableStorageCheck {
-		cli, err := dockerClient.NewEnvClient()
-		info, err := cli.Info(context.Background())
+	if !c.cfg.enableStorageCheck {
+		logrus.Debugf("Skipping storage check per user config")
+		return passResult("storage check disabled per user config")
+	}
+
+	cli, err := dockerClient.NewEnvClient()
+	if err != nil {
+		return failResult(fmt.Errorf("creating Docker client: %v", err))
+	}
+	defer cli.Close()
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return failResult(fmt.Errorf("querying Docker info: %v", err))
+	}
+
+	var data, metadata storageSpace
+	switch info.Driver {
+	case "devicemapper":
+		data, metadata, err = devicemapperSpaces(info.DriverStatus)
 		if err != nil {
-			panic(err)
+			return failResult(err)
 		}
-		for _, item := range info.DriverStatus {
-			if item[0] == "Data Space Available" {
-				dataSpaceFree, err = humanize.ParseBytes(item[1])
-				if err != nil {
-					panic(err)
-				}
-				promDockerDataStorageFree.Set(float64(dataSpaceFree))
-				logrus.Debugf("Found 'Data Space Available' value of ", item[1])
+	case "overlay2", "overlay", "aufs", "btrfs", "zfs":
+		data, metadata, err = filesystemSpaces(info.DockerRootDir)
+		if err != nil {
+			return failResult(fmt.Errorf("reading free space of %s: %v", info.DockerRootDir, err))
+		}
+	default:
+		return warnResult(fmt.Sprintf("storage driver %s is not supported by the storage check", info.Driver))
+	}
+	promDockerDataStorageFree.Set(float64(data.free))
+	promDockerMetadataStorageFree.Set(float64(metadata.free))
+	result := c.assess(info.Driver, data, metadata)
+
+	return result
+}
+
+// assess compares the spaces of driver against the thresholds. Free inodes, the metadata space of the
+// filesystem based drivers, are compared against the inode thresholds as a size makes no sense for them.
+func (c *CheckStorage) assess(driver string, data, metadata storageSpace) CheckResult {
+	metadataCrit, metadataWarn := c.cfg.metaDataStorageThreshold, c.cfg.metaDataStorageWarn
+	if metadata.unit == "inodes" {
+		metadataCrit, metadataWarn = c.cfg.inodeThreshold, c.cfg.inodeWarn
+	}
+
+	var critical, warnings []string
+	for _, space := range []struct {
+		storageSpace
+		crit, warn storageThreshold
+	}{
+		{data, c.cfg.dataStorageThreshold, c.cfg.dataStorageWarn},
+		{metadata, metadataCrit, metadataWarn},
+	} {
+		if space.crit.breached(space.free, space.total) {
+			critical = append(critical, fmt.Sprintf("'%s' of %s is below critical threshold %s",
+				space.name, space.format(space.free), space.crit))
+		} else if space.warn.breached(space.free, space.total) {
+			warnings = append(warnings, fmt.Sprintf("'%s' of %s is below warning threshold %s",
+				space.name, space.format(space.free), space.warn))
+		}
+	}
+
+	var result CheckResult
+	if len(critical) > 0 {
+		result = failResult(errors.New(strings.Join(append(critical, warnings...), ", ")))
+	} else if len(warnings) > 0 {
+		result = warnResult(strings.Join(warnings, ", "))
+	} else {
+		result = passResult(fmt.Sprintf("%s driver: %s %s and %s %s",
+			driver, data.format(data.free), data.name, metadata.format(metadata.free), metadata.name))
+	}
+	result.ObservedValue = data.free
+	result.ObservedUnit = data.unit
+	return result
+}
+
+// storageSpace is the free and total amount of one resource of the Docker storage driver, bytes or inodes
+type storageSpace struct {
+	name        string
+	unit        string
+	free, total uint64
+}
+
+func (s storageSpace) format(n uint64) string {
+	if s.unit == "inodes" {
+		return humanize.Comma(int64(n)) + " inodes"
+	}
+	return humanize.IBytes(n)
+}
+
+// devicemapperSpaces reads the thin pool data and metadata space from the devicemapper driver status
+func devicemapperSpaces(status [][2]string) (storageSpace, storageSpace, error) {
+	data := storageSpace{name: "Data Space Available", unit: "bytes"}
+	metadata := storageSpace{name: "Metadata Space Available", unit: "bytes"}
+	var err error
+	for _, item := range status {
+		if item[0] == "Data Space Available" {
+			data.free, err = humanize.ParseBytes(item[1])
+			if err != nil {
+				return data, metadata, fmt.Errorf("parsing 'Data Space Available' value %q: %v", item[1], err)
 			}
+			logrus.Debugf("Found 'Data Space Available' value of %s", item[1])
+		}
 
-			if item[0] == "Metadata Space Available" {
-				metadataSpaceFree, err = humanize.ParseBytes(item[1])
-				if err != nil {
-					panic(err)
-				}
-				promDockerMetadataStorageFree.Set(float64(metadataSpaceFree))
-				logrus.Debugf("Found 'Metadata Space Available' value of ", item[1])
+		if item[0] == "Metadata Space Available" {
+			metadata.free, err = humanize.ParseBytes(item[1])
+			if err != nil {
+				return data, metadata, fmt.Errorf("parsing 'Metadata Space Available' value %q: %v", item[1], err)
 			}
+			logrus.Debugf("Found 'Metadata Space Available' value of %s", item[1])
 		}
 
-		if dataSpaceFree < c.cfg.dataStorageThreshold {
-			logrus.Errorf("'Data Space Available' is below threshold, failing storage check")
-			c.fail()
+		// totals are only needed for percentage thresholds, an unparseable one leaves those unevaluated
+		if item[0] == "Data Space Total" {
+			data.total, _ = humanize.ParseBytes(item[1])
 		}
-		if metadataSpaceFree < c.cfg.metaDataStorageThreshold {
-			logrus.Errorf("'Metadata Space Available' is below threshold, failing storage check")
-			c.fail()
+		if item[0] == "Metadata Space Total" {
+			metadata.total, _ = humanize.ParseBytes(item[1])
 		}
-	} else {
-		logrus.Debugf("Skipping storage check per user config")
 	}
+	return data, metadata, nil
+}
 
-
-
-	logrus.WithFields(logrus.Fields{"before_eval": "false"}).Debug(spew.Sdump(c))
-	return true
+// diskUsage is the free and total space and inodes of a filesystem
+type diskUsage struct {
+	freeBytes, totalBytes   uint64
+	freeInodes, totalInodes uint64
 }
 
+// filesystemSpaces measures the filesystem holding the Docker root dir, used by the drivers that store
+// layers as plain files. Free bytes are checked against the data thresholds and free inodes against the
+// inode thresholds.
+func filesystemSpaces(rootDir string) (storageSpace, storageSpace, error) {
+	usage, err := statDisk(rootDir)
+	if err != nil {
+		return storageSpace{}, storageSpace{}, err
+	}
+	data := storageSpace{name: "Disk Space Available", unit: "bytes", free: usage.freeBytes, total: usage.totalBytes}
+	inodes := storageSpace{name: "Inodes Available", unit: "inodes", free: usage.freeInodes, total: usage.totalInodes}
+	return data, inodes, nil
+}
 
 // HTTP Server
 func checkState(w http.ResponseWriter, r *http.Request) {
-	if nodeHealth {
+	if wantsHealthJSON(r) {
+		writeHealthJSON(w, buildHealthDetail(checkSlice))
+		return
+	}
+	if wantsDetail(r) {
+		writeHealthDetail(w, buildHealthDetail(checkSlice))
+		return
+	}
+	if getNodeHealth() {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Everything OK"))
 	} else {
@@ -252,92 +572,425 @@ func checkState(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func evalChecks(checks []CheckInterface) {
-	for _, check := range checks {
-		check.eval()
+// runCheck evaluates a single check under its own deadline and records the result
+func runCheck(ctx context.Context, check CheckInterface) {
+	if result, ok := evaluate(ctx, check); ok {
+		check.record(result)
+	}
+}
+
+// evaluate runs check.eval under the deadline of the check. A check that is still running when the
+// deadline passes fails so a hung dependency can't stall the rest of the cycle, one interrupted by the
+// cancellation of ctx has no result and evaluate returns false.
+func evaluate(ctx context.Context, check CheckInterface) (CheckResult, bool) {
+	var cancel context.CancelFunc
+	if timeout := check.getTimeout(); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
 	}
-	for _, check := range checkSlice {
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan CheckResult, 1)
+	go func() {
+		// a panicking check must not take the server and the other checks down with it
+		defer func() {
+			if r := recover(); r != nil {
+				done <- CheckResult{
+					Status:  StatusFail,
+					Message: fmt.Sprintf("panic: %v\n%s", r, debug.Stack()),
+					Err:     fmt.Errorf("panic: %v", r),
+				}
+			}
+		}()
+		done <- check.eval(ctx)
+	}()
+
+	var result CheckResult
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		result = CheckResult{Status: StatusFail, Message: "timeout", Err: ctx.Err()}
+	case context.Canceled:
+		// the scheduler is stopping, an interrupted evaluation says nothing about the check
+		logrus.Debugf("Evaluation of check %s cancelled", check.getName())
+		return result, false
+	}
+	result.Time = start
+	result.Duration = time.Since(start)
+	return result, true
+}
+
+var updateNodeHealthMu sync.Mutex
+
+// updateNodeHealth recomputes the node health from scratch from the current status of every check, so
+// the node can recover once checks pass again
+func updateNodeHealth(checks []CheckInterface) {
+	// serialized so a verdict computed from older check states can't overwrite a newer one
+	updateNodeHealthMu.Lock()
+	defer updateNodeHealthMu.Unlock()
+	healthy := true
+	warnings := 0
+	var failing []string
+	for _, check := range checks {
 		logrus.Debugf("checkState - Reading state of check %s", check.getName())
-		if check.getStatus() == false {
-			nodeHealth = false
-			promNodeHealth.Set(1)
+		switch check.getDetail().severity() {
+		case StatusFail:
+			healthy = false
+			failing = append(failing, check.getName())
+		case StatusWarn:
+			warnings++
+		}
+	}
+	if previous := getNodeHealth(); previous != healthy {
+		notification := Notification{OldStatus: healthString(previous), NewStatus: healthString(healthy), Timestamp: time.Now()}
+		if !healthy {
+			notification.Message = "unhealthy checks: " + strings.Join(failing, ", ")
 		}
+		notify(notification)
 	}
+	setNodeHealth(healthy)
+	promNodeWarnings.Set(float64(warnings))
+}
+
+func getNodeHealth() bool {
+	nodeHealthMu.RLock()
+	defer nodeHealthMu.RUnlock()
+	return nodeHealth
 }
 
-func checkPoller(checks []CheckInterface, pollInterval int) {
-	evalChecks(checks) // call once for instant first tick
-	t := time.NewTicker(time.Second * time.Duration(pollInterval))
-	for range t.C {
-		evalChecks(checks)
+func setNodeHealth(healthy bool) {
+	nodeHealthMu.Lock()
+	defer nodeHealthMu.Unlock()
+	if healthy != nodeHealth {
+		logrus.Warnf("Node health changed from %t to %t", nodeHealth, healthy)
+	}
+	nodeHealth = healthy
+	if healthy {
+		promNodeHealthy.Set(1)
+		promNodeHealth.Set(0)
+	} else {
+		promNodeHealthy.Set(0)
+		promNodeHealth.Set(1)
 	}
 }
 
-func parseConfig() Config {
+// parseConfig reads the configuration from the environment. Every invalid setting is collected into
+// the returned configErrors rather than silently replaced by a zero value.
+func parseConfig() (Config, error) {
+	var errs configErrors
+
 	_logLevel, found := os.LookupEnv("LOG_LEVEL")
 	if found != true {
 		_logLevel = "WARN"
 	}
-	logLevel, _ := logrus.ParseLevel(_logLevel)
-
+	logLevel, err := logrus.ParseLevel(_logLevel)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %q is not a valid level, use one of debug, info, warn, error, fatal or panic", _logLevel))
+	}
 
 	_pollInterval, found := os.LookupEnv("POLL_INTERVAL")
 	if found != true {
 		_pollInterval = "10"
 	}
-	pollInterval,_ := strconv.Atoi(_pollInterval)
+	pollInterval, err := strconv.Atoi(_pollInterval)
+	if err != nil || pollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("POLL_INTERVAL: %q is not a positive whole number of seconds", _pollInterval))
+	}
 
 	_dataSpaceThreshold, found := os.LookupEnv("DATA_SPACE_THRESHOLD")
 	if found != true {
 		_dataSpaceThreshold = "1000"
 	}
-	dataSpaceThreshold,_ := strconv.ParseUint(_dataSpaceThreshold, 10, 64)
+	dataSpaceThreshold, err := parseStorageThreshold(_dataSpaceThreshold)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("DATA_SPACE_THRESHOLD: %v", err))
+	}
 
 	_metaDataSpaceThreshold, found := os.LookupEnv("METADATA_SPACE_THRESHOLD")
 	if found != true {
 		_metaDataSpaceThreshold = "1000"
 	}
-	metaDataSpaceThreshold,_ := strconv.ParseUint(_metaDataSpaceThreshold, 10, 64)
+	metaDataSpaceThreshold, err := parseStorageThreshold(_metaDataSpaceThreshold)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("METADATA_SPACE_THRESHOLD: %v", err))
+	}
+
+	dataSpaceWarn, err := parseStorageThreshold(os.Getenv("DATA_SPACE_WARN_THRESHOLD"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("DATA_SPACE_WARN_THRESHOLD: %v", err))
+	}
+
+	metaDataSpaceWarn, err := parseStorageThreshold(os.Getenv("METADATA_SPACE_WARN_THRESHOLD"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("METADATA_SPACE_WARN_THRESHOLD: %v", err))
+	}
+
+	_inodeThreshold, found := os.LookupEnv("INODE_THRESHOLD")
+	if found != true {
+		_inodeThreshold = "1000"
+	}
+	inodeThreshold, err := parseInodeThreshold(_inodeThreshold)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("INODE_THRESHOLD: %v", err))
+	}
+
+	inodeWarn, err := parseInodeThreshold(os.Getenv("INODE_WARN_THRESHOLD"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("INODE_WARN_THRESHOLD: %v", err))
+	}
 
 	enableStorageCheck := false
-	_enableStorageCheck , found := os.LookupEnv("ENABLE_STORAGE_CHECK")
+	_enableStorageCheck, found := os.LookupEnv("ENABLE_STORAGE_CHECK")
+	if found == true {
+		enableStorageCheck, err = strconv.ParseBool(strings.ToLower(_enableStorageCheck))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ENABLE_STORAGE_CHECK: %q is not true or false", _enableStorageCheck))
+		}
+	}
+
+	_checkTimeout, found := os.LookupEnv("CHECK_TIMEOUT")
 	if found != true {
-		enableStorageCheck = false
-	} else {
-		if strings.ToLower(_enableStorageCheck) == "true" {
-			enableStorageCheck = true
+		_checkTimeout = "10"
+	}
+	checkTimeout, err := strconv.Atoi(_checkTimeout)
+	if err != nil || checkTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("CHECK_TIMEOUT: %q is not a positive whole number of seconds", _checkTimeout))
+	}
+
+	_checkRise, found := os.LookupEnv("CHECK_RISE")
+	if found != true {
+		_checkRise = "1"
+	}
+	checkRise, err := strconv.Atoi(_checkRise)
+	if err != nil || checkRise < 1 {
+		errs = append(errs, fmt.Errorf("CHECK_RISE: %q is not a whole number of at least 1", _checkRise))
+	}
+
+	_checkFall, found := os.LookupEnv("CHECK_FALL")
+	if found != true {
+		_checkFall = "1"
+	}
+	checkFall, err := strconv.Atoi(_checkFall)
+	if err != nil || checkFall < 1 {
+		errs = append(errs, fmt.Errorf("CHECK_FALL: %q is not a whole number of at least 1", _checkFall))
+	}
+
+	_pollJitter, found := os.LookupEnv("POLL_JITTER")
+	if found != true {
+		_pollJitter = "10"
+	}
+	pollJitter, err := strconv.ParseFloat(_pollJitter, 64)
+	if err != nil || pollJitter < 0 || pollJitter >= 100 {
+		errs = append(errs, fmt.Errorf("POLL_JITTER: %q is not a percentage between 0 and 100", _pollJitter))
+	}
+
+	_pollSplay, found := os.LookupEnv("POLL_SPLAY")
+	if found != true {
+		_pollSplay = "10"
+	}
+	pollSplay, err := strconv.Atoi(_pollSplay)
+	if err != nil || pollSplay < 0 {
+		errs = append(errs, fmt.Errorf("POLL_SPLAY: %q is not a whole number of seconds", _pollSplay))
+	}
+
+	legacyNodeHealthMetric := false
+	_legacyNodeHealthMetric, found := os.LookupEnv("LEGACY_NODE_HEALTH_METRIC")
+	if found == true {
+		legacyNodeHealthMetric, err = strconv.ParseBool(strings.ToLower(_legacyNodeHealthMetric))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("LEGACY_NODE_HEALTH_METRIC: %q is not true or false", _legacyNodeHealthMetric))
+		}
+	}
+
+	enableDockerCollector := false
+	_enableDockerCollector, found := os.LookupEnv("ENABLE_DOCKER_COLLECTOR")
+	if found == true {
+		enableDockerCollector, err = strconv.ParseBool(strings.ToLower(_enableDockerCollector))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ENABLE_DOCKER_COLLECTOR: %q is not true or false", _enableDockerCollector))
 		}
 	}
 
-	return Config{
+	var notifyURLs []string
+	for _, raw := range strings.Split(os.Getenv("NOTIFY_WEBHOOK_URLS"), ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		if parsed, err := url.Parse(raw); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("NOTIFY_WEBHOOK_URLS: %q is not an http or https URL", raw))
+			continue
+		}
+		notifyURLs = append(notifyURLs, raw)
+	}
+
+	_notifyTimeout, found := os.LookupEnv("NOTIFY_TIMEOUT")
+	if found != true {
+		_notifyTimeout = "5"
+	}
+	notifyTimeout, err := strconv.Atoi(_notifyTimeout)
+	if err != nil || notifyTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("NOTIFY_TIMEOUT: %q is not a positive whole number of seconds", _notifyTimeout))
+	}
+
+	_notifyRetries, found := os.LookupEnv("NOTIFY_RETRIES")
+	if found != true {
+		_notifyRetries = "3"
+	}
+	notifyRetries, err := strconv.Atoi(_notifyRetries)
+	if err != nil || notifyRetries < 0 {
+		errs = append(errs, fmt.Errorf("NOTIFY_RETRIES: %q is not a whole number", _notifyRetries))
+	}
+
+	_notifyBackoff, found := os.LookupEnv("NOTIFY_BACKOFF")
+	if found != true {
+		_notifyBackoff = "1"
+	}
+	notifyBackoff, err := strconv.Atoi(_notifyBackoff)
+	if err != nil || notifyBackoff < 0 {
+		errs = append(errs, fmt.Errorf("NOTIFY_BACKOFF: %q is not a whole number of seconds", _notifyBackoff))
+	}
+
+	cfg := Config{
 		logLevel,
 		pollInterval,
 		dataSpaceThreshold,
 		metaDataSpaceThreshold,
+		dataSpaceWarn,
+		metaDataSpaceWarn,
+		inodeThreshold,
+		inodeWarn,
 		enableStorageCheck,
-
+		checkTimeout,
+		checkRise,
+		checkFall,
+		pollJitter / 100,
+		pollSplay,
+		legacyNodeHealthMetric,
+		enableDockerCollector,
+		notifyURLs,
+		notifyTimeout,
+		notifyRetries,
+		notifyBackoff,
 	}
-
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
 }
 
 func init() {
-	prometheus.MustRegister(promNodeHealth, promDockerDataStorageFree, promDockerMetadataStorageFree)
+	prometheus.MustRegister(promNodeHealthy, promNodeWarnings, promDockerDataStorageFree, promDockerMetadataStorageFree,
+		promCheckStatus, promCheckDuration, promCheckFailures, promCheckLastSuccess, promDNSLatency,
+		promBuildInfo, promCheckEnabled, promSchedulerCycleDuration, promSchedulerOverruns, promSchedulerMissedTicks)
+	promBuildInfo.WithLabelValues(VERSION, runtime.Version(), COMMIT).Set(1)
+	promNodeHealthy.Set(1)
 	promNodeHealth.Set(0)
 }
 
 func main() {
-	cfg := parseConfig()
+	configFlag := cli.StringFlag{
+		Name:   "config",
+		Usage:  "YAML or JSON file declaring the checks to run, defaults to the built in checks",
+		EnvVar: "CONFIG_FILE",
+	}
+
+	app := cli.NewApp()
+	app.Name = "cowcheck"
+	app.Usage = "A microservice for checking the health of a Rancher node"
+	app.Version = VERSION
+	app.Flags = []cli.Flag{configFlag}
+	app.Action = run
+	app.Commands = []cli.Command{
+		{
+			Name:  "config",
+			Usage: "Work with the cowcheck configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:   "validate",
+					Usage:  "Validate the environment and config file without starting cowcheck",
+					Flags:  []cli.Flag{configFlag},
+					Action: validate,
+				},
+			},
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+// loadConfig parses the environment and the config file, reporting the errors of both at once
+func loadConfig(path string) (Config, []CheckInterface, error) {
+	var errs configErrors
+	cfg, err := parseConfig()
+	if err != nil {
+		errs = append(errs, err.(configErrors)...)
+	}
+	checks, err := loadChecks(cfg, path)
+	if err != nil {
+		if fileErrs, ok := err.(configErrors); ok {
+			errs = append(errs, fileErrs...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return cfg, nil, errs
+	}
+	return cfg, checks, nil
+}
+
+func validate(c *cli.Context) error {
+	_, checks, err := loadConfig(c.String("config"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("Configuration OK, %d checks:\n", len(checks))
+	for _, check := range checks {
+		if dependencies := check.getDependencies(); len(dependencies) > 0 {
+			fmt.Printf("  %s (depends on %s)\n", check.getName(), strings.Join(dependencies, ", "))
+		} else {
+			fmt.Printf("  %s\n", check.getName())
+		}
+	}
+	return nil
+}
+
+func run(c *cli.Context) error {
+	cfg, checks, err := loadConfig(c.String("config"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 	logrus.SetLevel(cfg.logLevel)
 	logrus.Warn("Starting cowcheck...")
-	checkSlice = append(checkSlice, NewCheckDNS(), NewCheckMetadata(), NewCheckStorage(cfg))
-	go checkPoller(checkSlice, cfg.pollInterval)
+	if cfg.legacyNodeHealthMetric {
+		logrus.Warn("LEGACY_NODE_HEALTH_METRIC is set, cowcheck_node_cowcheck_node_health is deprecated in favour of cowcheck_node_healthy")
+		prometheus.MustRegister(promNodeHealth)
+	}
+	if cfg.enableDockerCollector {
+		prometheus.MustRegister(newDockerCollector(cfg))
+	}
+	if len(cfg.notifyURLs) > 0 {
+		notifier := newNotifier(cfg)
+		setNotifier(notifier)
+		go notifier.run(context.Background())
+	}
+	checkSlice = append(checkSlice, checks...)
+	exportEnabledChecks(checkSlice)
+	go newScheduler(cfg).run(context.Background(), checkSlice)
 
 	http.HandleFunc("/", checkState)
 	http.HandleFunc("/health", checkState)
-	http.Handle("/metrics", prometheusHandler())  // prometheus metrics endpoint
+	http.HandleFunc("/health/", checkStateByName)
+	http.HandleFunc("/livez", livez)
+	http.HandleFunc("/readyz", readyz)
+	http.Handle("/metrics", prometheusHandler()) // prometheus metrics endpoint
 
-	err := http.ListenAndServe(":5050", nil)
-	if err != nil {
-		logrus.Error(err)
-	}
+	return http.ListenAndServe(":5050", nil)
 }
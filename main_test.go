@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/Sirupsen/logrus"
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"testing"
 	"os"
-	"github.com/Sirupsen/logrus"
+	"strings"
+	"sync"
+	"testing"
+	"time"
 )
 
 var (
@@ -29,17 +34,217 @@ func NewFakeCheck() *FakeCheck {
 	}
 }
 
-func (c *FakeCheck) eval() bool {
+func (c *FakeCheck) eval(ctx context.Context) CheckResult {
 	fmt.Println("Evaluating check", c.name)
-	return true
+	return passResult("fake")
+}
+
+// SlowCheck blocks until its context is done, simulating a hung dependency
+type SlowCheck struct {
+	Check
+}
+
+func NewSlowCheck(timeout time.Duration) *SlowCheck {
+	return &SlowCheck{
+		Check{
+			name:          "SlowCheck",
+			description:   "SlowCheck",
+			currentStatus: true,
+			timeout:       timeout,
+		},
+	}
+}
+
+func (c *SlowCheck) eval(ctx context.Context) CheckResult {
+	<-ctx.Done()
+	return failResult(ctx.Err())
+}
+
+// ToggleCheck passes or fails according to its healthy field
+type ToggleCheck struct {
+	Check
+	healthy bool
+}
+
+func NewToggleCheck() *ToggleCheck {
+	return &ToggleCheck{
+		Check: Check{
+			name:          "ToggleCheck",
+			description:   "ToggleCheck",
+			currentStatus: true,
+		},
+		healthy: true,
+	}
+}
+
+func (c *ToggleCheck) eval(ctx context.Context) CheckResult {
+	if c.healthy {
+		return passResult("toggled on")
+	}
+	return failResult(errors.New("toggled off"))
+}
+
+// evalChecks evaluates every check once in parallel and updates the node health, like a cycle of the
+// scheduler in which every check is due at the same time
+func evalChecks(ctx context.Context, checks []CheckInterface) {
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check CheckInterface) {
+			defer wg.Done()
+			evalCheck(ctx, check, checks)
+		}(check)
+	}
+	wg.Wait()
+	updateNodeHealth(checks)
+	markPollCycle()
+}
+
+// withChecks makes checks the checks served by the handlers and evaluates them once, the previous
+// checks and node health are restored when the test is done
+func withChecks(t *testing.T, checks ...CheckInterface) {
+	saved := checkSlice
+	t.Cleanup(func() {
+		checkSlice = saved
+		setNodeHealth(true)
+	})
+	checkSlice = checks
+	evalChecks(context.Background(), checkSlice)
 }
 
 func TestCheckPoller(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	checkSlice = append(checkSlice, NewFakeCheck())
-	go checkPoller(checkSlice, 2)
+	go newScheduler(Config{pollInterval: 2}).run(ctx, checkSlice)
 
 }
 
+func TestRunCheckTimeout(t *testing.T) {
+	slow := NewSlowCheck(50 * time.Millisecond)
+	fake := NewFakeCheck()
+
+	start := time.Now()
+	evalChecks(context.Background(), []CheckInterface{slow, fake})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("evalChecks was blocked by a slow check for %v", elapsed)
+	}
+
+	if slow.getStatus() {
+		t.Errorf("Expected SlowCheck to fail after exceeding its deadline")
+	}
+	if result := slow.getLastResult(); result.Status != StatusFail || result.Message != "timeout" {
+		t.Errorf("Expected a failed result with message 'timeout', got %+v", result)
+	}
+	if !fake.getStatus() {
+		t.Errorf("Expected FakeCheck to be unaffected by SlowCheck")
+	}
+}
+
+func TestRunCheckCancelled(t *testing.T) {
+	slow := NewSlowCheck(time.Minute)
+	slow.currentStatus = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	runCheck(ctx, slow)
+
+	if slow.getStatus() {
+		t.Errorf("Expected a cancelled evaluation to leave SlowCheck unhealthy")
+	}
+	if result := slow.getLastResult(); !result.Time.IsZero() {
+		t.Errorf("Expected a cancelled evaluation not to be recorded, got %+v", result)
+	}
+}
+
+// PanicCheck panics on every evaluation
+type PanicCheck struct {
+	Check
+}
+
+func (c *PanicCheck) eval(ctx context.Context) CheckResult {
+	panic("something went badly wrong")
+}
+
+func TestRunCheckRecoversPanic(t *testing.T) {
+	defer setNodeHealth(true)
+	panicking := &PanicCheck{Check{name: "PanicCheck", currentStatus: true}}
+	fake := NewFakeCheck()
+
+	evalChecks(context.Background(), []CheckInterface{panicking, fake})
+
+	result := panicking.getLastResult()
+	if panicking.getStatus() || result.Status != StatusFail {
+		t.Errorf("Expected PanicCheck to fail, got %+v", result)
+	}
+	if !strings.Contains(result.Message, "something went badly wrong") || !strings.Contains(result.Message, "goroutine") {
+		t.Errorf("Expected the panic and its stack in the message, got %q", result.Message)
+	}
+	if !fake.getStatus() {
+		t.Errorf("Expected FakeCheck to be unaffected by PanicCheck")
+	}
+}
+
+func TestNodeHealthRecovers(t *testing.T) {
+	defer setNodeHealth(true)
+	check := NewToggleCheck()
+	checks := []CheckInterface{check}
+
+	check.healthy = false
+	evalChecks(context.Background(), checks)
+	if getNodeHealth() {
+		t.Errorf("Expected node to be unhealthy after a failed check")
+	}
+
+	check.healthy = true
+	evalChecks(context.Background(), checks)
+	if !getNodeHealth() {
+		t.Errorf("Expected node health to recover once the check passes again")
+	}
+}
+
+func TestRiseFall(t *testing.T) {
+	defer setNodeHealth(true)
+	check := NewToggleCheck()
+	check.rise = 2
+	check.fall = 3
+	checks := []CheckInterface{check}
+
+	check.healthy = false
+	for i := 1; i <= 3; i++ {
+		evalChecks(context.Background(), checks)
+		if expected := i < 3; check.getStatus() != expected {
+			t.Errorf("After %d consecutive failures expected status %t", i, expected)
+		}
+	}
+
+	check.healthy = true
+	for i := 1; i <= 2; i++ {
+		evalChecks(context.Background(), checks)
+		if expected := i >= 2; check.getStatus() != expected {
+			t.Errorf("After %d consecutive successes expected status %t", i, expected)
+		}
+	}
+}
+
+func TestCheckResult(t *testing.T) {
+	defer setNodeHealth(true)
+	check := NewToggleCheck()
+	check.healthy = false
+	evalChecks(context.Background(), []CheckInterface{check})
+
+	result := check.getLastResult()
+	if result.Status != StatusFail {
+		t.Errorf("Expected status %s, got %s", StatusFail, result.Status)
+	}
+	if result.Message != "toggled off" || result.Err == nil {
+		t.Errorf("Expected the failure reason to be carried in the result, got %+v", result)
+	}
+	if result.Time.IsZero() || result.Duration < 0 {
+		t.Errorf("Expected evaluation time and duration to be set, got %+v", result)
+	}
+}
+
 func TestHTTP(t *testing.T) {
 	// Borrowing example from https://elithrar.github.io/article/testing-http-handlers-go/
 
@@ -74,7 +279,10 @@ func TestHTTP(t *testing.T) {
 func TestParseConfig(t *testing.T) {
 	os.Setenv("LOG_LEVEL", "DEBUG")
 	os.Setenv("POLL_INTERVAL", "25")
-	cfg := parseConfig()
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
 	if cfg.logLevel == logrus.DebugLevel {
 		t.Logf("Found expected value for logLevel")
 	} else {
@@ -90,4 +298,193 @@ func TestParseConfig(t *testing.T) {
 
 }
 
+func TestParseConfigErrors(t *testing.T) {
+	invalid := map[string]string{
+		"LOG_LEVEL":                 "verbose",
+		"POLL_INTERVAL":             "10s",
+		"DATA_SPACE_THRESHOLD":      "lots",
+		"INODE_THRESHOLD":           "5GiB",
+		"ENABLE_STORAGE_CHECK":      "yes please",
+		"CHECK_FALL":                "0",
+		"LEGACY_NODE_HEALTH_METRIC": "maybe",
+		"ENABLE_DOCKER_COLLECTOR":   "on",
+		"NOTIFY_WEBHOOK_URLS":       "hooks.example.com/cowcheck",
+		"NOTIFY_RETRIES":            "-1",
+	}
+	for key, value := range invalid {
+		defer os.Unsetenv(key)
+		os.Setenv(key, value)
+	}
+
+	_, err := parseConfig()
+	errs, ok := err.(configErrors)
+	if !ok {
+		t.Fatalf("Expected configErrors, got %v", err)
+	}
+	if len(errs) != len(invalid) {
+		t.Errorf("Expected %d errors, got %d: %v", len(invalid), len(errs), errs)
+	}
+	for key := range invalid {
+		if !strings.Contains(errs.Error(), key) {
+			t.Errorf("Expected an error for %s in %v", key, errs)
+		}
+	}
+}
 
+func TestStorageThreshold(t *testing.T) {
+	for raw, expected := range map[string]storageThreshold{
+		"1000":   {raw: "1000", amount: 1000},
+		"5GiB":   {raw: "5GiB", amount: 5 * 1024 * 1024 * 1024},
+		"500 MB": {raw: "500 MB", amount: 500 * 1000 * 1000},
+		"10%":    {raw: "10%", percent: 10},
+		"":       {},
+	} {
+		threshold, err := parseStorageThreshold(raw)
+		if err != nil || threshold != expected {
+			t.Errorf("parseStorageThreshold(%q) = %+v, %v, want %+v", raw, threshold, err, expected)
+		}
+	}
+	for _, raw := range []string{"lots", "-5%", "150%"} {
+		if _, err := parseStorageThreshold(raw); err == nil {
+			t.Errorf("Expected parseStorageThreshold(%q) to fail", raw)
+		}
+	}
+
+	for raw, expected := range map[string]storageThreshold{
+		"100000": {raw: "100000", amount: 100000},
+		"5%":     {raw: "5%", percent: 5},
+	} {
+		threshold, err := parseInodeThreshold(raw)
+		if err != nil || threshold != expected {
+			t.Errorf("parseInodeThreshold(%q) = %+v, %v, want %+v", raw, threshold, err, expected)
+		}
+	}
+	if _, err := parseInodeThreshold("5GiB"); err == nil {
+		t.Errorf("Expected parseInodeThreshold to reject a size")
+	}
+
+	absolute, _ := parseStorageThreshold("1GB")
+	percent, _ := parseStorageThreshold("10%")
+	unset, _ := parseStorageThreshold("")
+	for _, test := range []struct {
+		threshold   storageThreshold
+		free, total uint64
+		breached    bool
+	}{
+		{absolute, 999 * 1000 * 1000, 0, true},
+		{absolute, 1000 * 1000 * 1000, 0, false},
+		{percent, 9, 100, true},
+		{percent, 10, 100, false},
+		{percent, 0, 0, false},
+		{unset, 0, 100, false},
+	} {
+		if breached := test.threshold.breached(test.free, test.total); breached != test.breached {
+			t.Errorf("%s breached(%d, %d) = %t, want %t", test.threshold, test.free, test.total, breached, test.breached)
+		}
+	}
+}
+
+func TestDevicemapperSpaces(t *testing.T) {
+	data, metadata, err := devicemapperSpaces([][2]string{
+		{"Pool Name", "docker-thinpool"},
+		{"Data Space Used", "1 GB"},
+		{"Data Space Total", "10 GB"},
+		{"Data Space Available", "9 GB"},
+		{"Metadata Space Total", "100 MB"},
+		{"Metadata Space Available", "50 MB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.free != 9*1000*1000*1000 || data.total != 10*1000*1000*1000 {
+		t.Errorf("unexpected data space: %+v", data)
+	}
+	if metadata.free != 50*1000*1000 || metadata.total != 100*1000*1000 {
+		t.Errorf("unexpected metadata space: %+v", metadata)
+	}
+}
+
+func TestDevicemapperSpacesUnparseable(t *testing.T) {
+	if _, _, err := devicemapperSpaces([][2]string{{"Data Space Available", "plenty"}}); err == nil {
+		t.Errorf("Expected an error for an unparseable size")
+	}
+}
+
+func TestFilesystemSpaces(t *testing.T) {
+	data, inodes, err := filesystemSpaces(os.TempDir())
+	if err != nil {
+		t.Skip(err)
+	}
+	if data.unit != "bytes" || data.total == 0 || data.free > data.total {
+		t.Errorf("unexpected disk space: %+v", data)
+	}
+	if inodes.unit != "inodes" || inodes.free > inodes.total {
+		t.Errorf("unexpected inodes: %+v", inodes)
+	}
+}
+
+func TestStorageInodeThresholds(t *testing.T) {
+	metadataThreshold, _ := parseStorageThreshold("5GiB")
+	inodeThreshold, _ := parseInodeThreshold("10%")
+	check := NewCheckStorage(Config{metaDataStorageThreshold: metadataThreshold, inodeThreshold: inodeThreshold})
+	data := storageSpace{name: "Disk Space Available", unit: "bytes", free: 50 << 30, total: 100 << 30}
+
+	inodes := storageSpace{name: "Inodes Available", unit: "inodes", free: 500000, total: 1000000}
+	if result := check.assess("overlay2", data, inodes); result.Status != StatusPass {
+		t.Errorf("Expected the metadata size threshold not to apply to inodes, got %+v", result)
+	}
+	inodes.free = 50000
+	if result := check.assess("overlay2", data, inodes); result.Status != StatusFail || !strings.Contains(result.Message, "below critical threshold 10%") {
+		t.Errorf("Expected the inode threshold to fail the check, got %+v", result)
+	}
+
+	metadata := storageSpace{name: "Metadata Space Available", unit: "bytes", free: 1 << 30, total: 10 << 30}
+	if result := check.assess("devicemapper", data, metadata); result.Status != StatusFail || !strings.Contains(result.Message, "below critical threshold 5GiB") {
+		t.Errorf("Expected the metadata threshold to fail the check, got %+v", result)
+	}
+}
+
+func TestCheckMetadata(t *testing.T) {
+	var response string
+	var code int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/latest/self/host" || r.Header.Get("Accept") != "application/json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(response))
+	}))
+	defer ts.Close()
+
+	check := NewCheckMetadata(Config{})
+	check.url = ts.URL
+	for _, test := range []struct {
+		code     int
+		response string
+		status   Status
+		contains string
+	}{
+		{http.StatusOK, `{"uuid": "3f1c", "hostname": "node-1", "agent_ip": "10.0.0.1"}`, StatusPass, "node-1"},
+		{http.StatusInternalServerError, `{"uuid": "3f1c", "hostname": "node-1"}`, StatusFail, "500 Internal Server Error"},
+		{http.StatusOK, `Not Found`, StatusFail, "parsing host record"},
+		{http.StatusOK, `{}`, StatusFail, "no uuid or hostname"},
+	} {
+		code, response = test.code, test.response
+		result := check.eval(context.Background())
+		if result.Status != test.status || !strings.Contains(result.Message, test.contains) {
+			t.Errorf("%d %s: got %s %q, want %s containing %q", test.code, test.response, result.Status, result.Message, test.status, test.contains)
+		}
+	}
+
+	code, response = http.StatusServiceUnavailable, ""
+	runCheck(context.Background(), check)
+	if check.getStatus() {
+		t.Errorf("Expected CheckMetadata to be unhealthy after a failure")
+	}
+	code, response = http.StatusOK, `{"uuid": "3f1c", "hostname": "node-1"}`
+	runCheck(context.Background(), check)
+	if !check.getStatus() {
+		t.Errorf("Expected CheckMetadata to recover once the metadata service answers again")
+	}
+}
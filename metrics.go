@@ -0,0 +1,113 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Per check metrics, labelled with the name and type of the check so a failing check can be told
+// apart from the others
+
+var promCheckStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "cowcheck",
+	Subsystem: "check",
+	Name:      "status",
+	Help:      "1 when the check is healthy, 0 when it is unhealthy",
+}, []string{"check", "type", "severity"})
+
+var promCheckDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "cowcheck",
+	Subsystem: "check",
+	Name:      "duration_seconds",
+	Help:      "Time taken by the evaluations of the check",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"check", "type"})
+
+var promCheckFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cowcheck",
+	Subsystem: "check",
+	Name:      "failures_total",
+	Help:      "Number of failed evaluations of the check, including timeouts",
+}, []string{"check", "type"})
+
+var promCheckLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "cowcheck",
+	Subsystem: "check",
+	Name:      "last_success_timestamp_seconds",
+	Help:      "Unix time of the last evaluation of the check that didn't fail",
+}, []string{"check", "type"})
+
+var promDNSLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "cowcheck",
+	Subsystem: "dns",
+	Name:      "latency_seconds",
+	Help:      "Slowest response of a nameserver to the queries of a DNS check in its last evaluation, per protocol",
+}, []string{"check", "server", "protocol"})
+
+var promBuildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "cowcheck",
+	Name:      "build_info",
+	Help:      "Always 1, labelled with the version, Go version and git commit cowcheck was built from",
+}, []string{"version", "goversion", "commit"})
+
+var promCheckEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "cowcheck",
+	Subsystem: "check",
+	Name:      "enabled",
+	Help:      "1 for every configured check that is enabled, 0 for one that is disabled, e.g. the storage check without ENABLE_STORAGE_CHECK",
+}, []string{"check"})
+
+var promSchedulerCycleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "cowcheck",
+	Subsystem: "scheduler",
+	Name:      "cycle_duration_seconds",
+	Help:      "Time taken by a scheduling cycle of the check, its evaluation and the update of the node health",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"check"})
+
+var promSchedulerOverruns = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cowcheck",
+	Subsystem: "scheduler",
+	Name:      "overruns_total",
+	Help:      "Number of cycles of the check that took longer than its interval",
+}, []string{"check"})
+
+var promSchedulerMissedTicks = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cowcheck",
+	Subsystem: "scheduler",
+	Name:      "missed_ticks_total",
+	Help:      "Number of intervals of the check that passed without an evaluation because of overruns",
+}, []string{"check"})
+
+// exportEnabledChecks exports which of the configured checks are enabled
+func exportEnabledChecks(checks []CheckInterface) {
+	for _, check := range checks {
+		enabled := 0.0
+		if check.isEnabled() {
+			enabled = 1
+		}
+		promCheckEnabled.WithLabelValues(check.getName()).Set(enabled)
+	}
+}
+
+// observe exports the state of the check after result was recorded, it must be called with c.mu held
+func (c *Check) observe(result CheckResult) {
+	severity := "critical"
+	if c.nonCritical {
+		severity = "non-critical"
+	}
+	healthy := 0.0
+	if c.currentStatus {
+		healthy = 1
+	}
+	promCheckStatus.WithLabelValues(c.name, c.checkType, severity).Set(healthy)
+
+	switch result.Status {
+	case StatusSkipped:
+		return
+	case StatusFail:
+		promCheckFailures.WithLabelValues(c.name, c.checkType).Inc()
+	default:
+		promCheckLastSuccess.WithLabelValues(c.name, c.checkType).Set(float64(c.lastEval.Unix()))
+	}
+	promCheckDuration.WithLabelValues(c.name, c.checkType).Observe(result.Duration.Seconds())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCheckMetrics(t *testing.T) {
+	check := NewToggleCheck()
+	check.name = "MetricsCheck"
+	check.checkType = "toggle"
+	check.nonCritical = true
+
+	failuresBefore := testutil.ToFloat64(promCheckFailures.WithLabelValues("MetricsCheck", "toggle"))
+	now := time.Now()
+	check.record(CheckResult{Status: StatusPass, Time: now, Duration: time.Millisecond})
+	check.record(CheckResult{Status: StatusFail, Err: errors.New("down"), Time: now.Add(time.Second), Duration: time.Millisecond})
+
+	if status := testutil.ToFloat64(promCheckStatus.WithLabelValues("MetricsCheck", "toggle", "non-critical")); status != 0 {
+		t.Errorf("Expected status 0 for the failed check, got %v", status)
+	}
+	if failures := testutil.ToFloat64(promCheckFailures.WithLabelValues("MetricsCheck", "toggle")) - failuresBefore; failures != 1 {
+		t.Errorf("Expected 1 failure, got %v", failures)
+	}
+	if lastSuccess := testutil.ToFloat64(promCheckLastSuccess.WithLabelValues("MetricsCheck", "toggle")); lastSuccess != float64(now.Unix()) {
+		t.Errorf("Expected the last success at %d, got %v", now.Unix(), lastSuccess)
+	}
+	if count := testutil.CollectAndCount(promCheckDuration, "cowcheck_check_duration_seconds"); count < 1 {
+		t.Errorf("Expected the duration histogram to be populated, got %d series", count)
+	}
+}
+
+func TestNodeHealthyMetric(t *testing.T) {
+	defer setNodeHealth(true)
+
+	setNodeHealth(false)
+	if healthy, legacy := testutil.ToFloat64(promNodeHealthy), testutil.ToFloat64(promNodeHealth); healthy != 0 || legacy != 1 {
+		t.Errorf("Expected cowcheck_node_healthy 0 and the legacy metric 1 when unhealthy, got %v and %v", healthy, legacy)
+	}
+	setNodeHealth(true)
+	if healthy, legacy := testutil.ToFloat64(promNodeHealthy), testutil.ToFloat64(promNodeHealth); healthy != 1 || legacy != 0 {
+		t.Errorf("Expected cowcheck_node_healthy 1 and the legacy metric 0 when healthy, got %v and %v", healthy, legacy)
+	}
+}
+
+func TestBuildInfoAndEnabledChecks(t *testing.T) {
+	if info := testutil.ToFloat64(promBuildInfo.WithLabelValues(VERSION, runtime.Version(), COMMIT)); info != 1 {
+		t.Errorf("Expected cowcheck_build_info 1, got %v", info)
+	}
+
+	exportEnabledChecks([]CheckInterface{NewCheckDNS(Config{}), NewCheckStorage(Config{})})
+	if enabled := testutil.ToFloat64(promCheckEnabled.WithLabelValues("CheckDNS")); enabled != 1 {
+		t.Errorf("Expected CheckDNS to be enabled, got %v", enabled)
+	}
+	if enabled := testutil.ToFloat64(promCheckEnabled.WithLabelValues("CheckStorage")); enabled != 0 {
+		t.Errorf("Expected CheckStorage to be disabled without enableStorageCheck, got %v", enabled)
+	}
+}
+
+func TestSchedulerOverruns(t *testing.T) {
+	s := &scheduler{interval: time.Second}
+	check := NewFakeCheck()
+	check.name = "OverrunCheck"
+
+	overrunsBefore := testutil.ToFloat64(promSchedulerOverruns.WithLabelValues("OverrunCheck"))
+	missedBefore := testutil.ToFloat64(promSchedulerMissedTicks.WithLabelValues("OverrunCheck"))
+	s.observeCycle(check, 100*time.Millisecond)
+	s.observeCycle(check, 2500*time.Millisecond)
+	if overruns := testutil.ToFloat64(promSchedulerOverruns.WithLabelValues("OverrunCheck")) - overrunsBefore; overruns != 1 {
+		t.Errorf("Expected 1 overrun, got %v", overruns)
+	}
+	if missed := testutil.ToFloat64(promSchedulerMissedTicks.WithLabelValues("OverrunCheck")) - missedBefore; missed != 2 {
+		t.Errorf("Expected 2 missed ticks, got %v", missed)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notification is the JSON payload POSTed to the webhooks when the node verdict or a check changes state
+type Notification struct {
+	Host string `json:"host"`
+	// Check is empty for a change of the overall node verdict
+	Check     string    `json:"check,omitempty"`
+	OldStatus string    `json:"old_status"`
+	NewStatus string    `json:"new_status"`
+	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// notifier delivers notifications to every webhook, each target has its own queue so a slow or down
+// target doesn't delay the others
+type notifier struct {
+	host    string
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	client  *http.Client
+	targets []webhookTarget
+}
+
+type webhookTarget struct {
+	url   string
+	queue chan Notification
+}
+
+// notificationQueueSize bounds the notifications waiting for a target, newer ones are dropped beyond it
+const notificationQueueSize = 100
+
+var activeNotifier *notifier
+var activeNotifierMu sync.RWMutex
+
+func newNotifier(cfg Config) *notifier {
+	host, err := os.Hostname()
+	if err != nil {
+		logrus.Warnf("Reading hostname for notifications: %v", err)
+	}
+	n := &notifier{
+		host:    host,
+		timeout: time.Duration(cfg.notifyTimeout) * time.Second,
+		retries: cfg.notifyRetries,
+		backoff: time.Duration(cfg.notifyBackoff) * time.Second,
+		client:  &http.Client{},
+	}
+	for _, url := range cfg.notifyURLs {
+		n.targets = append(n.targets, webhookTarget{url: url, queue: make(chan Notification, notificationQueueSize)})
+	}
+	return n
+}
+
+// setNotifier makes n receive the state transitions, nil disables notifications
+func setNotifier(n *notifier) {
+	activeNotifierMu.Lock()
+	defer activeNotifierMu.Unlock()
+	activeNotifier = n
+}
+
+// notify hands a state transition to the active notifier, if any. It never blocks so it is safe to
+// call while holding the lock of a check.
+func notify(notification Notification) {
+	activeNotifierMu.RLock()
+	defer activeNotifierMu.RUnlock()
+	if activeNotifier != nil {
+		activeNotifier.enqueue(notification)
+	}
+}
+
+func healthString(healthy bool) string {
+	if healthy {
+		return "healthy"
+	}
+	return "unhealthy"
+}
+
+func (n *notifier) enqueue(notification Notification) {
+	notification.Host = n.host
+	for _, target := range n.targets {
+		select {
+		case target.queue <- notification:
+		default:
+			logrus.Warnf("Notification queue of %s is full, dropping notification for %s", target.url, notification.Check)
+		}
+	}
+}
+
+// run delivers the queued notifications until ctx is cancelled
+func (n *notifier) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, target := range n.targets {
+		wg.Add(1)
+		go func(target webhookTarget) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case notification := <-target.queue:
+					if err := n.deliver(ctx, target.url, notification); err != nil {
+						logrus.Errorf("Notifying %s: %v", target.url, err)
+					}
+				}
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+// deliver POSTs notification to url, retrying failed attempts with an exponential backoff
+func (n *notifier) deliver(ctx context.Context, url string, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	backoff := n.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(ctx, url, body)
+		if err == nil || !retry || attempt >= n.retries {
+			return err
+		}
+		logrus.Warnf("Notifying %s failed, retrying in %s: %v", url, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt under the per-target timeout and reports whether a failure is
+// worth retrying, which client errors other than 429 are not
+func (n *notifier) post(ctx context.Context, url string, body []byte) (bool, error) {
+	if n.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotifierRetries(t *testing.T) {
+	var attempts int32
+	received := make(chan Notification, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var notification Notification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Error(err)
+		}
+		received <- notification
+	}))
+	defer ts.Close()
+
+	n := newNotifier(Config{notifyURLs: []string{ts.URL}, notifyTimeout: 1, notifyRetries: 2})
+	n.backoff = time.Millisecond
+	err := n.deliver(context.Background(), ts.URL, Notification{Check: "CheckDNS", OldStatus: "healthy", NewStatus: "unhealthy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notification := <-received; notification.Check != "CheckDNS" || notification.NewStatus != "unhealthy" {
+		t.Errorf("unexpected notification: %+v", notification)
+	}
+
+	atomic.StoreInt32(&attempts, 0)
+	n.retries = 1
+	if err := n.deliver(context.Background(), ts.URL, Notification{}); err == nil || atomic.LoadInt32(&attempts) != 2 {
+		t.Errorf("Expected the delivery to give up after 2 attempts, got %v after %d", err, attempts)
+	}
+}
+
+func TestNotifierNoRetryOnClientError(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	n := newNotifier(Config{notifyURLs: []string{ts.URL}, notifyTimeout: 1, notifyRetries: 3})
+	if err := n.deliver(context.Background(), ts.URL, Notification{}); err == nil || atomic.LoadInt32(&attempts) != 1 {
+		t.Errorf("Expected a single failed attempt, got %v after %d", err, attempts)
+	}
+}
+
+func TestNotifyOnTransitions(t *testing.T) {
+	defer setNodeHealth(true)
+	defer setNotifier(nil)
+
+	received := make(chan Notification, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notification Notification
+		if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+			t.Error(err)
+		}
+		received <- notification
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := newNotifier(Config{notifyURLs: []string{ts.URL}, notifyTimeout: 1})
+	setNotifier(n)
+	go n.run(ctx)
+
+	check := NewToggleCheck()
+	check.healthy = false
+	evalChecks(context.Background(), []CheckInterface{check})
+
+	got := map[string]Notification{}
+	for i := 0; i < 2; i++ {
+		select {
+		case notification := <-received:
+			got[notification.Check] = notification
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for notifications, got %+v", got)
+		}
+	}
+	if notification := got["ToggleCheck"]; notification.OldStatus != "healthy" || notification.NewStatus != "unhealthy" ||
+		notification.Message != "toggled off" || notification.Host != n.host {
+		t.Errorf("unexpected check notification: %+v", notification)
+	}
+	if notification := got[""]; notification.NewStatus != "unhealthy" || notification.Message != "unhealthy checks: ToggleCheck" {
+		t.Errorf("unexpected node notification: %+v", notification)
+	}
+}
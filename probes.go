@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollerState tracks whether the poller is still ticking, which is what /livez reports on
+type pollerState struct {
+	mu         sync.RWMutex
+	started    time.Time
+	lastCycle  time.Time
+	staleAfter time.Duration
+}
+
+var poller = pollerState{started: time.Now()}
+
+// markPollCycle records the completion of an evaluation cycle
+func markPollCycle() {
+	poller.mu.Lock()
+	defer poller.mu.Unlock()
+	poller.lastCycle = time.Now()
+}
+
+// setPollerStaleAfter sets how long the poller may go without completing a cycle before cowcheck is
+// no longer considered alive
+func setPollerStaleAfter(staleAfter time.Duration) {
+	poller.mu.Lock()
+	defer poller.mu.Unlock()
+	poller.staleAfter = staleAfter
+}
+
+func pollerAlive() (bool, string) {
+	poller.mu.RLock()
+	defer poller.mu.RUnlock()
+	if poller.staleAfter == 0 {
+		return true, ""
+	}
+	last := poller.lastCycle
+	if last.IsZero() {
+		last = poller.started
+	}
+	if since := time.Since(last); since > poller.staleAfter {
+		return false, fmt.Sprintf("no poll cycle completed in %s", since.Truncate(time.Second))
+	}
+	return true, ""
+}
+
+type probeResult struct {
+	name   string
+	ok     bool
+	reason string
+	// nonCritical results and skipped results of healthy checks are listed but don't fail the probe
+	nonCritical bool
+	skipped     bool
+}
+
+// probeFilter returns the check names listed in a query parameter, which may be repeated
+// (?exclude=a&exclude=b) or comma separated (?exclude=a,b)
+func probeFilter(r *http.Request, param string) map[string]bool {
+	names := map[string]bool{}
+	for _, value := range r.URL.Query()[param] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// writeProbe writes a kube-apiserver style probe response, listing every result when the probe failed
+// or ?verbose was requested
+func writeProbe(w http.ResponseWriter, r *http.Request, endpoint string, results []probeResult, warnings []string) {
+	failed := false
+	for _, result := range results {
+		if !result.ok && !result.nonCritical {
+			failed = true
+		}
+	}
+	_, verbose := r.URL.Query()["verbose"]
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if failed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !failed && !verbose {
+		fmt.Fprint(w, "ok")
+		return
+	}
+	for _, result := range results {
+		if result.skipped && !result.ok && !result.nonCritical {
+			fmt.Fprintf(w, "[-]%s failed (skipped): %s\n", result.name, result.reason)
+		} else if result.skipped {
+			fmt.Fprintf(w, "[-]%s skipped: %s\n", result.name, result.reason)
+		} else if result.ok {
+			fmt.Fprintf(w, "[+]%s ok\n", result.name)
+		} else if result.nonCritical {
+			fmt.Fprintf(w, "[-]%s failed (non-critical): %s\n", result.name, result.reason)
+		} else {
+			fmt.Fprintf(w, "[-]%s failed: %s\n", result.name, result.reason)
+		}
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "warn: %s\n", warning)
+	}
+	if failed {
+		fmt.Fprintf(w, "%s check failed\n", endpoint)
+	} else {
+		fmt.Fprintf(w, "%s check passed\n", endpoint)
+	}
+}
+
+// livez reports whether cowcheck itself is alive, i.e. the HTTP server answers and the poller is ticking.
+// It deliberately ignores the outcome of the checks so an unhealthy node doesn't restart cowcheck.
+func livez(w http.ResponseWriter, r *http.Request) {
+	alive, reason := pollerAlive()
+	writeProbe(w, r, "livez", []probeResult{
+		{name: "ping", ok: true},
+		{name: "poller", ok: alive, reason: reason},
+	}, nil)
+}
+
+// readyz reports whether the node is healthy, optionally restricted with ?include= or ?exclude=
+func readyz(w http.ResponseWriter, r *http.Request) {
+	include := probeFilter(r, "include")
+	exclude := probeFilter(r, "exclude")
+
+	results := []probeResult{}
+	known := map[string]bool{}
+	for _, check := range checkSlice {
+		name := check.getName()
+		known[name] = true
+		if (len(include) > 0 && !include[name]) || exclude[name] {
+			continue
+		}
+		detail := check.getDetail()
+		result := probeResult{
+			name:        name,
+			ok:          detail.Healthy,
+			nonCritical: !detail.Critical,
+			skipped:     detail.Status == StatusSkipped,
+		}
+		if !result.ok || result.skipped {
+			result.reason = detail.Message
+		}
+		results = append(results, result)
+	}
+
+	var warnings []string
+	for name := range include {
+		if !known[name] {
+			warnings = append(warnings, "unknown check in include: "+name)
+		}
+	}
+	for name := range exclude {
+		if !known[name] {
+			warnings = append(warnings, "unknown check in exclude: "+name)
+		}
+	}
+	sort.Strings(warnings)
+	writeProbe(w, r, "readyz", results, warnings)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyzExclude(t *testing.T) {
+	failing := NewToggleCheck()
+	failing.healthy = false
+	withChecks(t, NewFakeCheck(), failing)
+
+	for _, test := range []struct {
+		path     string
+		code     int
+		contains string
+	}{
+		{"/readyz", http.StatusServiceUnavailable, "[-]ToggleCheck failed: toggled off"},
+		{"/readyz?verbose&exclude=ToggleCheck", http.StatusOK, "[+]FakeCheck ok\nreadyz check passed"},
+		{"/readyz?include=FakeCheck", http.StatusOK, "ok"},
+		{"/readyz?verbose&exclude=ToggleCheck,NoSuchCheck", http.StatusOK, "warn: unknown check in exclude: NoSuchCheck"},
+	} {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(readyz).ServeHTTP(rr, httptest.NewRequest("GET", test.path, nil))
+		if rr.Code != test.code {
+			t.Errorf("%s returned wrong status code: got %v want %v", test.path, rr.Code, test.code)
+		}
+		if !strings.Contains(rr.Body.String(), test.contains) {
+			t.Errorf("%s returned unexpected body: got %q want it to contain %q", test.path, rr.Body.String(), test.contains)
+		}
+	}
+}
+
+func TestLivezStalePoller(t *testing.T) {
+	defer setPollerStaleAfter(0)
+
+	markPollCycle()
+	setPollerStaleAfter(time.Minute)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(livez).ServeHTTP(rr, httptest.NewRequest("GET", "/livez", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	setPollerStaleAfter(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(livez).ServeHTTP(rr, httptest.NewRequest("GET", "/livez", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(rr.Body.String(), "[-]poller failed") {
+		t.Errorf("handler returned unexpected body: %q", rr.Body.String())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"github.com/Sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// scheduler evaluates every check on its own interval instead of one shared ticker. Each wait is
+// randomly lengthened or shortened by jitter and the first evaluation is delayed by a random splay, so
+// hundreds of hosts started together don't hit shared services such as the Rancher metadata in lockstep.
+type scheduler struct {
+	// interval is used for checks that don't have their own
+	interval time.Duration
+	// jitter is the fraction of a check's interval each wait may deviate by, e.g. 0.1 for +/-10%
+	jitter float64
+	// splay is the upper bound of the random delay before a check is first evaluated
+	splay time.Duration
+}
+
+func newScheduler(cfg Config) *scheduler {
+	return &scheduler{
+		interval: time.Duration(cfg.pollInterval) * time.Second,
+		jitter:   cfg.pollJitter,
+		splay:    time.Duration(cfg.pollSplay) * time.Second,
+	}
+}
+
+// run evaluates the checks until ctx is cancelled
+func (s *scheduler) run(ctx context.Context, checks []CheckInterface) {
+	// /livez considers the scheduler stuck once no check has completed for a few of the shortest
+	// intervals, allowing for the longest check timeout on top
+	var minInterval, maxTimeout time.Duration
+	for _, check := range checks {
+		if interval := s.intervalOf(check); minInterval == 0 || interval < minInterval {
+			minInterval = interval
+		}
+		if check.getTimeout() > maxTimeout {
+			maxTimeout = check.getTimeout()
+		}
+	}
+	setPollerStaleAfter(3 * (minInterval + maxTimeout + s.splay))
+
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check CheckInterface) {
+			defer wg.Done()
+			s.loop(ctx, check, checks)
+		}(check)
+	}
+	wg.Wait()
+}
+
+func (s *scheduler) loop(ctx context.Context, check CheckInterface, checks []CheckInterface) {
+	splay := s.splay
+	if interval := s.intervalOf(check); interval < splay {
+		splay = interval
+	}
+	timer := time.NewTimer(randomDuration(splay))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		start := time.Now()
+		evalCheck(ctx, check, checks)
+		updateNodeHealth(checks)
+		markPollCycle()
+		s.observeCycle(check, time.Since(start))
+		timer.Reset(s.nextDelay(s.intervalOf(check)))
+	}
+}
+
+// observeCycle exports how long a cycle of check took, a cycle longer than the interval of the check
+// is an overrun that misses the ticks it ran over
+func (s *scheduler) observeCycle(check CheckInterface, elapsed time.Duration) {
+	promSchedulerCycleDuration.WithLabelValues(check.getName()).Observe(elapsed.Seconds())
+	if interval := s.intervalOf(check); interval > 0 && elapsed > interval {
+		logrus.Warnf("Check %s took %s, longer than its interval of %s", check.getName(), elapsed, interval)
+		promSchedulerOverruns.WithLabelValues(check.getName()).Inc()
+		promSchedulerMissedTicks.WithLabelValues(check.getName()).Add(float64(elapsed / interval))
+	}
+}
+
+func (s *scheduler) intervalOf(check CheckInterface) time.Duration {
+	if interval := check.getInterval(); interval > 0 {
+		return interval
+	}
+	return s.interval
+}
+
+// nextDelay returns interval randomly lengthened or shortened by up to the configured jitter
+func (s *scheduler) nextDelay(interval time.Duration) time.Duration {
+	spread := time.Duration(float64(interval) * s.jitter)
+	return interval - spread + randomDuration(2*spread)
+}
+
+// randomDuration returns a random duration in [0, max)
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
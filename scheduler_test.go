@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// CountingCheck counts its evaluations
+type CountingCheck struct {
+	Check
+	mu    sync.Mutex
+	evals int
+}
+
+func NewCountingCheck(name string, interval time.Duration) *CountingCheck {
+	return &CountingCheck{
+		Check: Check{
+			name:          name,
+			description:   name,
+			currentStatus: true,
+			interval:      interval,
+		},
+	}
+}
+
+func (c *CountingCheck) eval(ctx context.Context) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evals++
+	return passResult("counted")
+}
+
+func (c *CountingCheck) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evals
+}
+
+func TestSchedulerPerCheckInterval(t *testing.T) {
+	defer setPollerStaleAfter(0)
+	fast := NewCountingCheck("fast", 10*time.Millisecond)
+	slow := NewCountingCheck("slow", time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s := &scheduler{interval: time.Second, jitter: 0.1}
+	s.run(ctx, []CheckInterface{fast, slow})
+
+	if fast.count() < 5 {
+		t.Errorf("Expected the fast check to be evaluated many times, got %d", fast.count())
+	}
+	if slow.count() != 1 {
+		t.Errorf("Expected the slow check to be evaluated once, got %d", slow.count())
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	s := &scheduler{jitter: 0.1}
+	interval := 10 * time.Second
+	for i := 0; i < 1000; i++ {
+		if delay := s.nextDelay(interval); delay < 9*time.Second || delay >= 11*time.Second {
+			t.Fatalf("Delay %v is outside of the 10%% jitter around %v", delay, interval)
+		}
+	}
+
+	s.jitter = 0
+	if delay := s.nextDelay(interval); delay != interval {
+		t.Errorf("Expected no jitter, got %v", delay)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux
+// +build linux
+
+package main
+
+import "syscall"
+
+func statDisk(path string) (diskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return diskUsage{}, err
+	}
+	return diskUsage{
+		freeBytes:   uint64(st.Bavail) * uint64(st.Bsize),
+		totalBytes:  uint64(st.Blocks) * uint64(st.Bsize),
+		freeInodes:  uint64(st.Ffree),
+		totalInodes: uint64(st.Files),
+	}, nil
+}
+
+// fileUsage returns the disk space allocated to a file, which for a sparse file such as a devicemapper
+// loop file is less than its size
+func fileUsage(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Blocks) * 512, nil
+}
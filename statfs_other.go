@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func statDisk(path string) (diskUsage, error) {
+	return diskUsage{}, errors.New("measuring free disk space is not supported on " + runtime.GOOS)
+}
+
+func fileUsage(path string) (uint64, error) {
+	return 0, errors.New("measuring file usage is not supported on " + runtime.GOOS)
+}